@@ -2,24 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	expvarmw "github.com/gofiber/fiber/v2/middleware/expvar"
 	"github.com/redis/go-redis/v9"
 
 	_ "github.com/TheAmirMohammad/otp-service/docs" // swagger docs
 
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+	memchallenge "github.com/TheAmirMohammad/otp-service/internal/challenge/memory"
+	redchallenge "github.com/TheAmirMohammad/otp-service/internal/challenge/redis"
 	"github.com/TheAmirMohammad/otp-service/internal/config"
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
 	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
 	httpapi "github.com/TheAmirMohammad/otp-service/internal/http"
 	"github.com/TheAmirMohammad/otp-service/internal/http/handlers"
 	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
 	"github.com/TheAmirMohammad/otp-service/internal/infra/postgres"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
 	"github.com/TheAmirMohammad/otp-service/internal/otp"
 	mem "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
 	red "github.com/TheAmirMohammad/otp-service/internal/otp/redis"
+	"github.com/TheAmirMohammad/otp-service/internal/otp/sender"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	memphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/memory"
+	redphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/redis"
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+	redrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/redis"
+	"github.com/TheAmirMohammad/otp-service/internal/totp"
 )
 
 // @title           OTP Service API
@@ -30,64 +51,367 @@ import (
 // @in              header
 // @name            Authorization
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	cfg := config.Load()
 
-	usersRepo := buildUserRepo(ctx, cfg)
-	otpSvc, limiter := buildOTPStack(ctx, cfg)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(ctx, cfg, os.Args[2:]))
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	usersRepo, refreshRepo := buildRepos(ctx, cfg)
+	rdb := buildRedis(ctx, cfg)
+	phones := buildPhones(cfg)
+	format := buildOTPFormat(cfg)
+	phoneList := buildPhoneList(ctx, cfg, rdb, phones, format, usersRepo)
+	otpSvc, limiter, puzzles := buildOTPStack(ctx, cfg, rdb, format, phoneList)
+	totpPolicy := otp.Policy{Limit: cfg.TOTPMaxAttempts, Window: cfg.TOTPWindow}
+	totpCipher := buildTOTPCipher(cfg)
+	limits := handlers.OTPLimits{
+		Phone:      otp.Policy{Limit: cfg.RateLimitMax, Window: cfg.RateLimitWindow},
+		IP:         otp.Policy{Limit: cfg.RateLimitIPMax, Window: cfg.RateLimitIPWindow},
+		Global:     otp.Policy{Limit: cfg.RateLimitGlobalMax, Window: cfg.RateLimitGlobalWindow},
+		Challenges: otp.Policy{Limit: cfg.ChallengeIssueMax, Window: cfg.ChallengeIssueWindow},
+		PhoneSoft:  cfg.ChallengePhoneAfter,
+		IPSoft:     cfg.ChallengeIPAfter,
+		IPv4Prefix: cfg.RateLimitIPv4Prefix,
+		IPv6Prefix: cfg.RateLimitIPv6Prefix,
+	}
+	revocations := buildRevocations(cfg, rdb)
+	keys := buildKeySet(cfg)
 
 	ah := &handlers.AuthHandler{
-		Users:     usersRepo,
-		OTP:       otpSvc,
-		Limiter:   limiter,
-		JWTSecret: cfg.JWTSecret,
-		TokenTTL:  cfg.TokenTTL,
+		Users:          usersRepo,
+		Phones:         phones,
+		PhoneList:      phoneList,
+		OTP:            otpSvc,
+		OTPFormat:      format,
+		Limiter:        limiter,
+		Limits:         limits,
+		Challenge:      buildChallenge(cfg, puzzles),
+		Tokens:         &jwtutil.Signer{Keys: keys, Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience},
+		Verifier:       jwtutil.NewVerifier(keys, cfg.JWTIssuer, cfg.JWTAudience, cfg.JWTLeeway),
+		TokenTTL:       cfg.TokenTTL,
+		RefreshTokens:  refreshRepo,
+		RefreshTTL:     cfg.RefreshTokenTTL,
+		Revocations:    revocations,
+		AdminPhones:    normalizePhones(phones, cfg.AdminPhones),
+		MFATokenTTL:    cfg.MFATokenTTL,
+		StepUpTokenTTL: cfg.StepUpTokenTTL,
+		TOTPPolicy:     totpPolicy,
+		TOTPCipher:     totpCipher,
+	}
+	uh := &handlers.UserHandler{Users: usersRepo, Phones: phones, PhoneList: phoneList, Verifier: ah.Verifier, RefreshTokens: refreshRepo, Revocations: revocations}
+	th := &handlers.TOTPHandler{Users: usersRepo, Limiter: limiter, Policy: totpPolicy, Issuer: cfg.TOTPIssuer, Cipher: totpCipher}
+	adm := &handlers.AdminHandler{
+		Users:         usersRepo,
+		RefreshTokens: refreshRepo,
+		Revocations:   revocations,
+		Phones:        phones,
+		OTP:           otpSvc,
+		Limiter:       limiter,
+		Limits:        limits,
+		PhoneList:     phoneList,
 	}
-	uh := &handlers.UserHandler{Users: usersRepo}
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableIPValidation:      true, // c.IP() is a real IP, never the raw header
+		EnableTrustedProxyCheck: true, // Validate requires TrustedProxies with ProxyHeader
+		TrustedProxies:          cfg.TrustedProxies,
+	})
 	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("OK") })
-	httpapi.New(app, ah, uh)
+	if cfg.DebugVars {
+		app.Use(expvarmw.New()) // GET /debug/vars
+	}
+	httpapi.New(app, ah, uh, th, adm)
 
-	log.Printf("config: otp_ttl=%v rate=%d/%v token_ttl=%v", cfg.OTPTTL, cfg.RateLimitMax, cfg.RateLimitWindow, cfg.TokenTTL)
+	log.Printf("config: otp_ttl=%v otp_format=%q otp_max_attempts=%d rate phone=%d/%v ip=%d/%v global=%d/%v token_ttl=%v refresh_ttl=%v",
+		cfg.OTPTTL, format, cfg.OTPMaxAttempts, cfg.RateLimitMax, cfg.RateLimitWindow, cfg.RateLimitIPMax, cfg.RateLimitIPWindow,
+		cfg.RateLimitGlobalMax, cfg.RateLimitGlobalWindow, cfg.TokenTTL, cfg.RefreshTokenTTL)
+	go func() {
+		<-ctx.Done()
+		log.Printf("shutting down (waiting up to %v for in-flight requests)", cfg.ShutdownTimeout)
+		if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+			log.Printf("warning: shutdown: %v", err)
+		}
+	}()
 	log.Printf("listening on :%s", cfg.Port)
 	if err := app.Listen(":" + cfg.Port); err != nil {
 		log.Fatal(err)
 	}
+	otpSvc.Close()
+	limiter.Close()
+	puzzles.Close()
+	if rdb != nil {
+		rdb.Close()
+	}
+	log.Println("bye")
 }
 
-// buildUserRepo wires Postgres if available, otherwise falls back to memory.
-func buildUserRepo(ctx context.Context, cfg config.Config) user.Repository {
+// buildRepos wires Postgres if available, otherwise falls back to memory.
+func buildRepos(ctx context.Context, cfg config.Config) (user.Repository, token.Repository) {
 	if strings.TrimSpace(cfg.DatabaseURL) == "" {
 		log.Println("users repo: in-memory (DATABASE_URL empty or USE_DB=false)")
-		return memory.NewUserRepo()
+		return memory.NewUserRepo(), memory.NewRefreshRepo(ctx, cfg.MemorySweepInterval)
 	}
 	db, err := postgres.Connect(ctx, cfg.DatabaseURL)
 	if err != nil {
 		log.Printf("warning: postgres unavailable (%v) – using in-memory users repo", err)
-		return memory.NewUserRepo()
+		return memory.NewUserRepo(), memory.NewRefreshRepo(ctx, cfg.MemorySweepInterval)
 	}
-	if err := postgres.Migrate(ctx, db); err != nil {
-		log.Printf("warning: migration failed (%v) – using in-memory users repo", err)
-		return memory.NewUserRepo()
+	if cfg.DBAutoMigrate {
+		if err := postgres.Migrate(ctx, db); err != nil {
+			log.Printf("warning: migration failed (%v) – using in-memory users repo", err)
+			return memory.NewUserRepo(), memory.NewRefreshRepo(ctx, cfg.MemorySweepInterval)
+		}
 	}
 	log.Println("users repo: postgres")
-	return postgres.NewUserRepo(db)
+	return postgres.NewUserRepo(db), postgres.NewRefreshRepo(ctx, db, cfg.RefreshPruneInterval, cfg.RefreshRevokedRetention)
 }
 
-// buildOTPStack wires Redis-backed OTP & rate if available, otherwise falls back to memory.
-func buildOTPStack(ctx context.Context, cfg config.Config) (otp.Service, otp.Limiter) {
+// buildRedis connects to Redis if configured and reachable; nil means in-memory fallback.
+func buildRedis(ctx context.Context, cfg config.Config) *redis.Client {
 	if strings.TrimSpace(cfg.RedisURL) == "" {
 		log.Println("otp/rate: in-memory (REDIS_URL empty or USE_REDIS=false)")
-		return mem.NewManager(cfg.OTPTTL), mem.NewLimiter(cfg.RateLimitMax, cfg.RateLimitWindow)
+		return nil
 	}
 	rdb := redis.NewClient(mustParseRedisURL(cfg.RedisURL))
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		log.Printf("warning: redis unavailable (%v) – using in-memory OTP & rate", err)
-		return mem.NewManager(cfg.OTPTTL), mem.NewLimiter(cfg.RateLimitMax, cfg.RateLimitWindow)
+		return nil
 	}
 	log.Println("otp/rate: redis")
-	return red.NewManager(rdb, cfg.OTPTTL), red.NewLimiter(rdb, cfg.RateLimitMax, cfg.RateLimitWindow)
+	return rdb
+}
+
+// buildOTPStack wires Redis-backed OTP, rate & challenge state if available, otherwise
+// falls back to memory. The memory janitors stop when ctx is done.
+func buildOTPStack(ctx context.Context, cfg config.Config, rdb *redis.Client, format otp.Format, tests otp.TestNumbers) (otp.Service, otp.Limiter, challenge.Store) {
+	opts := otp.Options{
+		TTL:             cfg.OTPTTL,
+		PurposeTTLs:     purposeTTLs(cfg.OTPPurposeTTLs),
+		Format:          format,
+		MaxAttempts:     cfg.OTPMaxAttempts,
+		Hasher:          otp.NewHasher(cfg.OTPSecret, cfg.OTPPreviousSecrets),
+		Sender:          buildSender(cfg),
+		TestNumbers:     tests,
+		AcceptLegacy:    cfg.OTPAcceptLegacy,
+		ResendCooldowns: cfg.OTPResendCooldowns,
+		ResendReset:     cfg.OTPResendReset,
+	}
+	janitor := mem.JanitorOptions{Interval: cfg.MemorySweepInterval, MaxKeys: cfg.MemoryMaxKeys}
+	limiter := buildLimiter(ctx, rdb, janitor)
+	if rdb == nil {
+		return mem.NewManager(ctx, opts, janitor), limiter, memchallenge.NewStore(ctx, cfg.MemoryMaxKeys, cfg.MemorySweepInterval)
+	}
+	return red.NewManager(rdb, opts), limiter, redchallenge.NewStore(rdb)
+}
+
+// buildTOTPCipher encrypts TOTP secrets with TOTP_ENCRYPTION_KEY (and opens ones sealed
+// with TOTP_PREVIOUS_ENCRYPTION_KEYS).
+func buildTOTPCipher(cfg config.Config) *totp.Cipher {
+	c, err := totp.NewCipher(cfg.TOTPKey, cfg.TOTPPrevKeys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// buildOTPFormat validates OTP_LENGTH and OTP_ALPHABET; a bad format is fatal.
+func buildOTPFormat(cfg config.Config) otp.Format {
+	f, err := otp.NewFormat(cfg.OTPLength, cfg.OTPAlphabet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+// purposeTTLs parses OTP_PURPOSE_TTLS ("purpose=duration"); bad entries are fatal.
+func purposeTTLs(raw []string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, r := range raw {
+		purpose, d, ok := strings.Cut(r, "=")
+		purpose = strings.TrimSpace(purpose)
+		ttl, err := time.ParseDuration(strings.TrimSpace(d))
+		if !ok || err != nil || ttl <= 0 || !otp.ValidPurpose(purpose) {
+			log.Fatalf("OTP_PURPOSE_TTLS: %q must be purpose=duration with purpose one of %v", r, otp.Purposes)
+		}
+		out[purpose] = ttl
+	}
+	return out
+}
+
+// buildChallenge picks what request-otp asks for past its soft thresholds (CHALLENGE_KIND);
+// nil turns them off.
+func buildChallenge(cfg config.Config, puzzles challenge.Store) challenge.Provider {
+	switch cfg.ChallengeKind {
+	case "off", "none":
+		log.Println("challenge: off")
+		return nil
+	case "", challenge.KindPoW:
+		log.Printf("challenge: proof-of-work (difficulty %d)", cfg.ChallengePoWDifficulty)
+		return challenge.NewPoW(puzzles, cfg.ChallengePoWDifficulty, cfg.ChallengeTTL)
+	case challenge.KindCaptcha:
+		switch cfg.CaptchaProvider {
+		case "fake":
+			log.Println("warning: challenge: fake captcha – accepts CAPTCHA_FAKE_TOKEN, never use in production")
+			return challenge.NewCaptcha(challenge.NewFake(cfg.CaptchaFakeToken), cfg.CaptchaSiteKey)
+		case "", "siteverify":
+			if cfg.CaptchaVerifyURL == "" || cfg.CaptchaSecret == "" {
+				log.Fatal("CHALLENGE_KIND=captcha requires CAPTCHA_VERIFY_URL and CAPTCHA_SECRET")
+			}
+			log.Printf("challenge: captcha (%s)", cfg.CaptchaVerifyURL)
+			return challenge.NewCaptcha(challenge.NewSiteVerify(cfg.CaptchaVerifyURL, cfg.CaptchaSecret, cfg.CaptchaTimeout), cfg.CaptchaSiteKey)
+		default:
+			log.Fatalf("unknown CAPTCHA_PROVIDER=%q (siteverify | fake)", cfg.CaptchaProvider)
+		}
+	default:
+		log.Printf("warning: unknown CHALLENGE_KIND=%q – using proof-of-work", cfg.ChallengeKind)
+		return challenge.NewPoW(puzzles, cfg.ChallengePoWDifficulty, cfg.ChallengeTTL)
+	}
+	return nil
+}
+
+// buildLimiter returns a Redis limiter when rdb is set, otherwise an in-memory one.
+func buildLimiter(ctx context.Context, rdb *redis.Client, j mem.JanitorOptions) otp.Limiter {
+	if rdb == nil {
+		return mem.NewLimiter(ctx, j)
+	}
+	return red.NewLimiter(rdb)
+}
+
+// buildRevocations keeps the token denylist in Redis when available, otherwise in memory.
+// Per-user cutoffs only need to outlive the longest access or MFA token, plus the
+// leeway the verifier still accepts it for.
+func buildRevocations(cfg config.Config, rdb *redis.Client) revocation.Store {
+	ttl := max(cfg.TokenTTL, cfg.MFATokenTTL) + cfg.JWTLeeway
+	if rdb == nil {
+		return memrevocation.NewStore(ttl)
+	}
+	return redrevocation.NewStore(rdb, ttl)
+}
+
+// buildKeySet signs with JWT_PRIVATE_KEY_FILE (RS256/EdDSA) when set, otherwise HS256 with JWT_SECRET.
+func buildKeySet(cfg config.Config) *jwtutil.KeySet {
+	if cfg.JWTPrivateKeyFile == "" {
+		log.Println("jwt: HS256 (shared JWT_SECRET)")
+		return jwtutil.NewHMACKeySet(cfg.JWTSecret)
+	}
+	keys, err := jwtutil.LoadKeySet(cfg.JWTPrivateKeyFile, cfg.JWTKeyID, cfg.JWTPublicKeyFiles)
+	if err != nil {
+		log.Fatalf("load jwt keys: %v", err)
+	}
+	log.Printf("jwt: %v (%d verification keys)", keys.Methods(), len(keys.JWKS().Keys))
+	return keys
+}
+
+// buildPhones builds the phone normalizer; a bad region or calling code is fatal.
+func buildPhones(cfg config.Config) *phone.Normalizer {
+	n, err := phone.NewNormalizer(cfg.PhoneDefaultRegion, cfg.PhoneAllowedCountries, cfg.PhoneDeniedCountries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n
+}
+
+// buildPhoneList loads the config entries of the phone list; admin entries live in
+// Redis when available, otherwise in memory. Invalid entries are fatal; test numbers
+// that belong to a real user are skipped, or anyone could log in with the fixed code.
+func buildPhoneList(ctx context.Context, cfg config.Config, rdb *redis.Client, n *phone.Normalizer, format otp.Format, users user.Repository) *phonelist.List {
+	var entries []phonelist.Entry
+	add := func(kind, raw, code string) {
+		pattern := strings.TrimSpace(raw)
+		if !strings.HasSuffix(pattern, "*") {
+			p, err := n.Normalize(pattern)
+			if err != nil {
+				log.Fatalf("phone list: %q: %v", raw, err)
+			}
+			pattern = p
+		}
+		entries = append(entries, phonelist.Entry{Pattern: pattern, Kind: kind, Code: code})
+	}
+	for _, p := range cfg.PhoneBlocklist {
+		add(phonelist.KindBlock, p, "")
+	}
+	for _, p := range cfg.PhoneAllowlist {
+		add(phonelist.KindAllow, p, "")
+	}
+	for _, t := range cfg.PhoneTestNumbers {
+		p, code, ok := strings.Cut(t, "=")
+		if !ok {
+			log.Fatalf("PHONE_TEST_NUMBERS: %q must be phone=code", t)
+		}
+		add(phonelist.KindTest, p, strings.TrimSpace(code))
+	}
+	entries = slices.DeleteFunc(entries, func(e phonelist.Entry) bool {
+		if e.Kind != phonelist.KindTest {
+			return false
+		}
+		u, err := users.GetByPhone(ctx, e.Pattern)
+		switch {
+		case errors.Is(err, user.ErrNotFound):
+			return false
+		case err != nil:
+			log.Printf("warning: PHONE_TEST_NUMBERS: skipping %s, cannot check its owner: %v", e.Pattern, err)
+			return true
+		case !u.TestAccount:
+			log.Printf("warning: PHONE_TEST_NUMBERS: skipping %s, it belongs to user %s", e.Pattern, u.ID)
+			return true
+		}
+		return false
+	})
+
+	var store phonelist.Store = memphonelist.NewStore()
+	if rdb != nil {
+		store = redphonelist.NewStore(rdb)
+	}
+	l, err := phonelist.New(store, entries, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(entries) > 0 {
+		log.Printf("phone list: %d config entries", len(entries))
+	}
+	return l
+}
+
+// normalizePhones converts configured phones to E.164, dropping invalid ones.
+func normalizePhones(n *phone.Normalizer, raw []string) []string {
+	var out []string
+	for _, p := range raw {
+		e164, err := n.Normalize(p)
+		if err != nil {
+			log.Printf("warning: ignoring phone %q: %v", p, err)
+			continue
+		}
+		out = append(out, e164)
+	}
+	return out
+}
+
+// buildSender picks the OTP delivery channel from OTP_SENDER (console by default).
+func buildSender(cfg config.Config) otp.Sender {
+	switch cfg.OTPSender {
+	case "", "console":
+		log.Println("otp sender: console")
+		return sender.NewConsole()
+	case "file":
+		log.Printf("otp sender: file (%s)", cfg.OTPOutboxPath)
+		return sender.NewFile(cfg.OTPOutboxPath)
+	case "webhook":
+		if cfg.SMSWebhookURL == "" {
+			log.Fatal("OTP_SENDER=webhook requires SMS_WEBHOOK_URL")
+		}
+		log.Println("otp sender: webhook")
+		return sender.NewWebhook(cfg.SMSWebhookURL, cfg.SMSWebhookToken, cfg.SMSWebhookTimeout)
+	default:
+		log.Printf("warning: unknown OTP_SENDER=%q – using console", cfg.OTPSender)
+		return sender.NewConsole()
+	}
 }
 
 // mustParseRedisURL accepts either "host:port" or "redis://[:pass@]host:port[/db]"
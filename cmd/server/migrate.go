@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/TheAmirMohammad/otp-service/internal/config"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/postgres"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/migrations"
+)
+
+const migrateUsage = "usage: otp-service migrate up | down [n] | status | phones [apply]"
+
+// runMigrate implements `otp-service migrate ...` and returns the exit code.
+func runMigrate(ctx context.Context, cfg config.Config, args []string) int {
+	if len(args) == 0 {
+		log.Println(migrateUsage)
+		return 2
+	}
+	if cfg.DatabaseURL == "" {
+		log.Println("migrate: no database configured (USE_DB=false or DATABASE_URL empty)")
+		return 1
+	}
+	db, err := postgres.Connect(ctx, cfg.DatabaseURL)
+	if err != nil {
+		log.Printf("migrate: %v", err)
+		return 1
+	}
+	defer db.Close()
+	m, err := postgres.NewMigrator(db, migrations.FS)
+	if err != nil {
+		log.Printf("migrate: %v", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, v := range done {
+			log.Printf("applied %03d", v)
+		}
+		if err != nil {
+			log.Printf("migrate up: %v", err)
+			return 1
+		}
+		if len(done) == 0 {
+			log.Println("nothing to apply")
+		}
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				log.Println(migrateUsage)
+				return 2
+			}
+		}
+		done, err := m.Down(ctx, n)
+		for _, v := range done {
+			log.Printf("rolled back %03d", v)
+		}
+		if err != nil {
+			log.Printf("migrate down: %v", err)
+			return 1
+		}
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			log.Printf("migrate status: %v", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range st {
+			at := "pending"
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.UTC().Format("2006-01-02 15:04:05Z")
+			}
+			fmt.Fprintf(w, "%03d\t%s\t%s\n", s.Version, s.Name, at)
+		}
+		w.Flush()
+	case "phones":
+		apply := len(args) > 1 && args[1] == "apply"
+		if len(args) > 1 && !apply {
+			log.Println(migrateUsage)
+			return 2
+		}
+		return backfillPhones(ctx, cfg, db, apply)
+	default:
+		log.Println(migrateUsage)
+		return 2
+	}
+	return 0
+}
+
+// backfillPhones rewrites users.phone rows stored before normalization to E.164
+// and lists what it changed (or would change without apply). Phones that would
+// collide are listed and left for an admin to merge; they make the exit code 1.
+func backfillPhones(ctx context.Context, cfg config.Config, db *pgxpool.Pool, apply bool) int {
+	// the country policy only gates logins; existing accounts are normalized regardless
+	n, err := phone.NewNormalizer(cfg.PhoneDefaultRegion, nil, nil)
+	if err != nil {
+		log.Printf("migrate phones: %v", err)
+		return 1
+	}
+	res, err := postgres.BackfillPhones(ctx, db, n.Normalize, apply)
+	if err != nil {
+		log.Printf("migrate phones: %v", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tUSER\tPHONE\tE.164")
+	status := "would change"
+	if apply {
+		status = "changed"
+	}
+	for _, c := range res.Changed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status, c.ID, c.From, c.To)
+	}
+	for _, g := range res.Collisions {
+		for _, c := range g {
+			fmt.Fprintf(w, "collision\t%s\t%s\t%s\n", c.ID, c.From, c.To)
+		}
+	}
+	for _, c := range res.Invalid {
+		fmt.Fprintf(w, "invalid\t%s\t%s\t-\n", c.ID, c.From)
+	}
+	w.Flush()
+	log.Printf("%d %s, %d collisions, %d invalid", len(res.Changed), status, len(res.Collisions), len(res.Invalid))
+	if !apply && len(res.Changed) > 0 {
+		log.Println("dry run: re-run with `migrate phones apply` to write")
+	}
+	if len(res.Collisions) > 0 {
+		return 1
+	}
+	return 0
+}
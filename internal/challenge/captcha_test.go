@@ -0,0 +1,53 @@
+package challenge_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+)
+
+func TestCaptchaSiteVerify(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		ok := r.PostForm.Get("secret") == "s3cret" && r.PostForm.Get("response") == "good" && r.PostForm.Get("remoteip") == "203.0.113.7"
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			_, _ = w.Write([]byte(`{"success":true}`))
+		} else {
+			_, _ = w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := challenge.NewCaptcha(challenge.NewSiteVerify(srv.URL, "s3cret", time.Second), "site-key")
+	if ch, err := p.Issue(ctx); err != nil || ch.Kind != challenge.KindCaptcha || ch.SiteKey != "site-key" {
+		t.Fatalf("Issue = %+v, %v", ch, err)
+	}
+	for token, want := range map[string]bool{"good": true, "bad": false, "": false} {
+		if ok, err := p.Verify(ctx, challenge.Solution{Token: token}, "203.0.113.7"); err != nil || ok != want {
+			t.Errorf("Verify(%q) = %v, %v; want %v", token, ok, err, want)
+		}
+	}
+
+	srv.Close()
+	if _, err := p.Verify(ctx, challenge.Solution{Token: "good"}, ""); err == nil {
+		t.Error("Verify with the provider down: want an error")
+	}
+}
+
+func TestCaptchaFake(t *testing.T) {
+	ctx := context.Background()
+	p := challenge.NewCaptcha(challenge.NewFake("pass"), "")
+	for token, want := range map[string]bool{"pass": true, "fail": false, "": false} {
+		if ok, err := p.Verify(ctx, challenge.Solution{Token: token}, ""); err != nil || ok != want {
+			t.Errorf("Verify(%q) = %v, %v; want %v", token, ok, err, want)
+		}
+	}
+}
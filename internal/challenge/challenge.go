@@ -0,0 +1,63 @@
+// Package challenge makes clients prove they are not a script before they may
+// trigger more SMS: a server-issued proof-of-work puzzle or a third-party CAPTCHA.
+package challenge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStoreFull is returned by Issue when the Store holds as many unsolved puzzles as
+// it may; live puzzles are never evicted, so clients mid-solve are not broken.
+var ErrStoreFull = errors.New("challenge: too many unsolved puzzles")
+
+// Challenge kinds
+const (
+	KindPoW     = "pow"
+	KindCaptcha = "captcha"
+)
+
+// Challenge is handed to a client that must solve it before retrying.
+type Challenge struct {
+	Kind string `json:"kind"` // pow | captcha
+
+	// pow: find a nonce such that SHA-256(seed + ":" + nonce) starts with Difficulty zero bits
+	ID         string    `json:"id,omitempty"`
+	Seed       string    `json:"seed,omitempty"`
+	Difficulty int       `json:"difficulty,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitzero"`
+
+	// captcha: render the provider widget with this key and send back its token
+	SiteKey string `json:"site_key,omitempty"`
+}
+
+// Solution is a client's answer: ID and Nonce for pow, Token for a captcha.
+type Solution struct {
+	ID    string `json:"id,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// Provider issues challenges and checks their solutions.
+type Provider interface {
+	Issue(ctx context.Context) (Challenge, error)
+	// Verify reports whether s solves a challenge issued by this provider. Solutions are single use.
+	Verify(ctx context.Context, s Solution, remoteIP string) (bool, error)
+}
+
+// Puzzle is the server side of an issued proof-of-work challenge.
+type Puzzle struct {
+	Seed       string
+	Difficulty int
+}
+
+// Store keeps issued puzzles until they are solved or expire (both memory & redis implement).
+type Store interface {
+	// Put fails with ErrStoreFull when no more puzzles may be kept.
+	Put(ctx context.Context, id string, p Puzzle, ttl time.Duration) error
+	// Take returns and deletes the puzzle, so each one is solved at most once. Nil if unknown or expired.
+	Take(ctx context.Context, id string) (*Puzzle, error)
+	// Close stops background work (the memory sweeper); it does not close shared clients.
+	Close() error
+}
@@ -0,0 +1,98 @@
+package memorychallenge
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+)
+
+type store struct {
+	mu      sync.Mutex
+	maxKeys int
+	puzzles map[string]entry
+	now     func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type entry struct {
+	challenge.Puzzle
+	ExpiresAt time.Time
+}
+
+// NewStore holds at most maxKeys unsolved puzzles (<= 0 is unbounded); when full, Put
+// fails with challenge.ErrStoreFull until puzzles are solved or swept. Expired ones are
+// swept every interval (<= 0 disables) until Close or ctx is done.
+func NewStore(ctx context.Context, maxKeys int, interval time.Duration) challenge.Store {
+	s := &store{
+		maxKeys: maxKeys,
+		puzzles: make(map[string]entry),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if interval <= 0 {
+		close(s.done)
+		return s
+	}
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.sweep()
+			case <-s.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *store) Put(_ context.Context, id string, p challenge.Puzzle, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxKeys > 0 && len(s.puzzles) >= s.maxKeys {
+		return challenge.ErrStoreFull
+	}
+	s.puzzles[id] = entry{Puzzle: p, ExpiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *store) Take(_ context.Context, id string) (*challenge.Puzzle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.puzzles[id]
+	delete(s.puzzles, id)
+	if !ok || !s.now().Before(e.ExpiresAt) {
+		return nil, nil
+	}
+	return &e.Puzzle, nil
+}
+
+// Close stops the sweeper and waits for a running sweep to finish. It is safe to call twice.
+func (s *store) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+	return nil
+}
+
+// sweep drops expired puzzles.
+func (s *store) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, e := range s.puzzles {
+		if !now.Before(e.ExpiresAt) {
+			delete(s.puzzles, id)
+		}
+	}
+}
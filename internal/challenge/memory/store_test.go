@@ -0,0 +1,67 @@
+package memorychallenge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+)
+
+func TestStoreFull(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(ctx, 2, 0).(*store)
+	defer s.Close()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	p := challenge.Puzzle{Difficulty: 8, Seed: "seed"}
+	for _, id := range []string{"a", "b"} {
+		if err := s.Put(ctx, id, p, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(ctx, "c", p, time.Minute); !errors.Is(err, challenge.ErrStoreFull) {
+		t.Fatalf("Put at the cap = %v, want ErrStoreFull", err)
+	}
+	if got, _ := s.Take(ctx, "a"); got == nil {
+		t.Fatal("live puzzle was evicted")
+	}
+	if err := s.Put(ctx, "c", p, time.Minute); err != nil {
+		t.Fatalf("Put after a Take = %v", err)
+	}
+
+	// expired puzzles still count until swept; Put does not prune
+	now = now.Add(2 * time.Minute)
+	if err := s.Put(ctx, "d", p, time.Minute); !errors.Is(err, challenge.ErrStoreFull) {
+		t.Fatalf("Put before the sweep = %v, want ErrStoreFull", err)
+	}
+	s.sweep()
+	if err := s.Put(ctx, "d", p, time.Minute); err != nil {
+		t.Fatalf("Put after the sweep = %v", err)
+	}
+}
+
+func TestStoreSweeper(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(ctx, 0, time.Millisecond).(*store)
+	if err := s.Put(ctx, "a", challenge.Puzzle{}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.puzzles)
+		s.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired puzzle was not swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_ = s.Close()
+	_ = s.Close() // idempotent
+}
@@ -0,0 +1,85 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxDifficulty keeps puzzles solvable by a browser in reasonable time.
+const MaxDifficulty = 32
+
+type pow struct {
+	store      Store
+	difficulty int
+	ttl        time.Duration
+}
+
+// NewPoW issues hashcash-style puzzles of the given difficulty (leading zero bits),
+// valid for ttl. Each expected solve costs the client about 2^difficulty hashes.
+func NewPoW(store Store, difficulty int, ttl time.Duration) Provider {
+	return &pow{store: store, difficulty: min(max(difficulty, 1), MaxDifficulty), ttl: ttl}
+}
+
+func (p *pow) Issue(ctx context.Context) (Challenge, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return Challenge{}, err
+	}
+	id, seed := uuid.NewString(), hex.EncodeToString(b[:])
+	if err := p.store.Put(ctx, id, Puzzle{Seed: seed, Difficulty: p.difficulty}, p.ttl); err != nil {
+		return Challenge{}, err
+	}
+	return Challenge{
+		Kind:       KindPoW,
+		ID:         id,
+		Seed:       seed,
+		Difficulty: p.difficulty,
+		ExpiresAt:  time.Now().Add(p.ttl).UTC(),
+	}, nil
+}
+
+func (p *pow) Verify(ctx context.Context, s Solution, _ string) (bool, error) {
+	if s.ID == "" || s.Nonce == "" || len(s.Nonce) > 64 {
+		return false, nil
+	}
+	pz, err := p.store.Take(ctx, s.ID)
+	if err != nil || pz == nil {
+		return false, err
+	}
+	return Solved(pz.Seed, s.Nonce, pz.Difficulty), nil
+}
+
+// Solve brute-forces a nonce for seed; it is what a client does (used by tests and tools).
+func Solve(seed string, difficulty int) string {
+	for n := uint64(0); ; n++ {
+		nonce := strconv.FormatUint(n, 10)
+		if Solved(seed, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
+
+// Solved reports whether nonce solves the puzzle of seed at difficulty.
+func Solved(seed, nonce string, difficulty int) bool {
+	return leadingZeroBits(seed, nonce) >= difficulty
+}
+
+// leadingZeroBits counts the zero bits SHA-256(seed + ":" + nonce) starts with.
+func leadingZeroBits(seed, nonce string) int {
+	sum := sha256.Sum256([]byte(seed + ":" + nonce))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package challenge_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+	memorychallenge "github.com/TheAmirMohammad/otp-service/internal/challenge/memory"
+	redischallenge "github.com/TheAmirMohammad/otp-service/internal/challenge/redis"
+)
+
+func stores(t *testing.T) map[string]func() challenge.Store {
+	return map[string]func() challenge.Store{
+		"memory": func() challenge.Store {
+			s := memorychallenge.NewStore(context.Background(), 0, 0)
+			t.Cleanup(func() { _ = s.Close() })
+			return s
+		},
+		"redis": func() challenge.Store {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { _ = rdb.Close() })
+			return redischallenge.NewStore(rdb)
+		},
+	}
+}
+
+func TestPoW(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			p := challenge.NewPoW(newStore(), 8, time.Minute)
+			verify := func(s challenge.Solution, want bool) {
+				t.Helper()
+				if ok, err := p.Verify(ctx, s, ""); err != nil || ok != want {
+					t.Fatalf("Verify(%+v) = %v, %v; want %v", s, ok, err, want)
+				}
+			}
+
+			ch, err := p.Issue(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.Kind != challenge.KindPoW || ch.ID == "" || ch.Seed == "" || ch.Difficulty != 8 {
+				t.Fatalf("Issue = %+v", ch)
+			}
+			nonce := challenge.Solve(ch.Seed, ch.Difficulty)
+			verify(challenge.Solution{ID: ch.ID, Nonce: nonce}, true)
+			verify(challenge.Solution{ID: ch.ID, Nonce: nonce}, false) // single use
+
+			ch, _ = p.Issue(ctx)
+			verify(challenge.Solution{ID: ch.ID, Nonce: badNonce(ch)}, false)
+			verify(challenge.Solution{ID: ch.ID, Nonce: challenge.Solve(ch.Seed, ch.Difficulty)}, false) // burnt by the wrong try
+
+			other, _ := p.Issue(ctx)
+			verify(challenge.Solution{ID: "unknown", Nonce: challenge.Solve(other.Seed, other.Difficulty)}, false)
+			verify(challenge.Solution{ID: other.ID}, false)
+		})
+	}
+}
+
+func TestPoWExpires(t *testing.T) {
+	ctx := context.Background()
+	p := challenge.NewPoW(memorychallenge.NewStore(context.Background(), 0, 0), 4, time.Millisecond)
+	ch, err := p.Issue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := p.Verify(ctx, challenge.Solution{ID: ch.ID, Nonce: challenge.Solve(ch.Seed, ch.Difficulty)}, ""); ok {
+		t.Fatal("expired puzzle accepted")
+	}
+}
+
+// badNonce returns a nonce that does not solve ch.
+func badNonce(ch challenge.Challenge) string {
+	n := "x"
+	for challenge.Solved(ch.Seed, n, ch.Difficulty) {
+		n += "x"
+	}
+	return n
+}
@@ -0,0 +1,47 @@
+package redischallenge
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+)
+
+type store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) challenge.Store {
+	return &store{rdb: rdb}
+}
+
+// challenge:<id> -> "<difficulty>:<seed>", expiring with the puzzle
+func (s *store) Put(ctx context.Context, id string, p challenge.Puzzle, ttl time.Duration) error {
+	return s.rdb.Set(ctx, key(id), fmt.Sprintf("%d:%s", p.Difficulty, p.Seed), ttl).Err()
+}
+
+func (s *store) Take(ctx context.Context, id string) (*challenge.Puzzle, error) {
+	val, err := s.rdb.GetDel(ctx, key(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d, seed, ok := strings.Cut(val, ":")
+	difficulty, err := strconv.Atoi(d)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("challenge %s: malformed puzzle", id)
+	}
+	return &challenge.Puzzle{Seed: seed, Difficulty: difficulty}, nil
+}
+
+// Close is a no-op: Redis expires the keys and the client belongs to the caller.
+func (s *store) Close() error { return nil }
+
+func key(id string) string { return fmt.Sprintf("challenge:%s", id) }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,8 +13,19 @@ import (
 )
 
 type Config struct {
-	Port      string
-	JWTSecret string
+	Port        string
+	JWTSecret   string   // HS256 key, required unless JWTPrivateKeyFile is set
+	AdminPhones []string // always granted the admin role on login
+
+	// Asymmetric JWT signing (empty private key => HS256 with JWTSecret)
+	JWTPrivateKeyFile string   // PEM, RSA => RS256, Ed25519 => EdDSA
+	JWTKeyID          string   // "kid"; defaults to the key's RFC 7638 thumbprint
+	JWTPublicKeyFiles []string // extra verification keys ("path" or "kid=path"), e.g. the previous key
+
+	// Claims enforced on every token
+	JWTIssuer   string        // "iss", default otp-service
+	JWTAudience string        // "aud", default otp-service
+	JWTLeeway   time.Duration // clock skew allowed on exp/iat, default 30s
 
 	// Toggles
 	UseDB    bool
@@ -23,6 +35,15 @@ type Config struct {
 	DatabaseURL string
 	RedisURL    string
 
+	DBAutoMigrate bool // apply pending migrations on startup (default true)
+
+	// In-memory OTP & rate limiting (USE_REDIS=false)
+	MemorySweepInterval time.Duration // expired entries are evicted this often, default 1m; 0 disables
+	MemoryMaxKeys       int           // hard cap on tracked keys per map (new keys refused), default 100000; 0 is unbounded
+
+	ShutdownTimeout time.Duration // in-flight requests get this long on SIGINT/SIGTERM, default 10s
+	DebugVars       bool          // serve expvar stats on /debug/vars (default false)
+
 	// Base pieces to build URLs
 	PGUser     string
 	PGPassword string
@@ -35,10 +56,83 @@ type Config struct {
 	RedisDB   int
 
 	// ⚙️ Tunables
-	OTPTTL         time.Duration // default 2m
-	RateLimitMax   int           // default 3
+	OTPTTL          time.Duration // default 2m
+	OTPLength       int           // 4-10, default 6
+	OTPAlphabet     string        // numeric (default) | alphanumeric
+	OTPPurposeTTLs  []string      // "purpose=duration" overrides of OTPTTL, e.g. change_phone=10m
+	OTPMaxAttempts  int           // default 5 wrong guesses per code
+	RateLimitMax    int           // per phone, default 3
 	RateLimitWindow time.Duration // default 10m
-	TokenTTL       time.Duration // default 24h
+	TokenTTL        time.Duration // access token lifetime, default 15m
+	RefreshTokenTTL time.Duration // default 720h (30 days)
+
+	// Postgres refresh token pruning (the memory repo uses MemorySweepInterval)
+	RefreshPruneInterval    time.Duration // dead refresh tokens are deleted this often, default 1h; 0 disables
+	RefreshRevokedRetention time.Duration // revoked tokens are kept this long first, default 24h
+
+	// Extra request-otp layers against SMS pumping; 0 disables a layer
+	RateLimitIPMax        int           // per client subnet, default 10
+	RateLimitIPWindow     time.Duration // default 10m
+	RateLimitIPv4Prefix   int           // subnet size for IPv4 clients, default 32
+	RateLimitIPv6Prefix   int           // default 64
+	RateLimitGlobalMax    int           // whole service, default 300
+	RateLimitGlobalWindow time.Duration // default 1m
+
+	// Soft request-otp thresholds: past them a challenge must be solved first
+	ChallengeKind          string        // pow | captcha | off (default pow)
+	ChallengePhoneAfter    int           // requests per phone in RATE_LIMIT_WINDOW, default 2; 0 disables
+	ChallengeIPAfter       int           // requests per subnet in RATE_LIMIT_IP_WINDOW, default 5; 0 disables
+	ChallengeTTL           time.Duration // how long a puzzle may be solved, default 2m
+	ChallengeIssueMax      int           // challenges issued per subnet in ChallengeIssueWindow, default 20
+	ChallengeIssueWindow   time.Duration // default 10m
+	ChallengePoWDifficulty int           // leading zero bits, default 20 (~1M hashes)
+
+	// Third-party CAPTCHA (CHALLENGE_KIND=captcha)
+	CaptchaProvider  string        // siteverify (reCAPTCHA, hCaptcha, Turnstile) | fake
+	CaptchaVerifyURL string        // siteverify endpoint
+	CaptchaSiteKey   string        // handed to clients to render the widget
+	CaptchaSecret    string        // server-side secret for siteverify
+	CaptchaTimeout   time.Duration // default 5s
+	CaptchaFakeToken string        // fake provider: the only accepted token, default "pass"
+
+	// Client IP behind a reverse proxy
+	ProxyHeader    string   // e.g. X-Forwarded-For; empty uses the socket address
+	TrustedProxies []string // only these peers may set ProxyHeader (required with it)
+
+	// OTP storage: codes are kept as HMAC(OTP_SECRET, phone:code)
+	OTPSecret          string   // required, must differ from JWT_SECRET
+	OTPPreviousSecrets []string // still accepted after a rotation
+	OTPAcceptLegacy    bool     // accept plaintext codes from pre-hashing deployments (default false)
+
+	// Resend cooldown: wait after the 1st, 2nd, ... code in a row (last one repeats)
+	OTPResendCooldowns []time.Duration // default 30s,60s,120s; "0" disables
+	OTPResendReset     time.Duration   // sequence restarts after this long without sends, default 1h
+
+	// TOTP second factor
+	TOTPIssuer      string        // label shown in authenticator apps
+	TOTPKey         string        // encrypts TOTP secrets at rest; required, distinct from the other secrets
+	TOTPPrevKeys    []string      // still decrypt secrets after a key rotation
+	MFATokenTTL     time.Duration // lifetime of the partial token after OTP, default 5m
+	StepUpTokenTTL  time.Duration // lifetime of the token after a non-login OTP, default 5m
+	TOTPMaxAttempts int           // TOTP guesses per user per TOTPWindow, default 5
+	TOTPWindow      time.Duration // default 5m
+
+	// OTP delivery
+	OTPSender         string        // console | file | webhook (default console)
+	OTPOutboxPath     string        // file sender target
+	SMSWebhookURL     string        // webhook sender target
+	SMSWebhookToken   string        // optional bearer token for the gateway
+	SMSWebhookTimeout time.Duration // default 5s
+
+	// Phone numbers are stored and rate limited in E.164
+	PhoneDefaultRegion    string   // region for national formats like 0912..., default IR
+	PhoneAllowedCountries []string // calling codes (e.g. 98); empty allows all
+	PhoneDeniedCountries  []string // calling codes always rejected
+
+	// Phone list (more entries via the admin API): numbers or "+prefix*" patterns
+	PhoneBlocklist   []string // refused with 403 "phone blocked"
+	PhoneAllowlist   []string // exceptions inside blocked prefixes
+	PhoneTestNumbers []string // "phone=code": fixed code, never delivered (QA, app review)
 }
 
 func Load() Config {
@@ -48,8 +142,16 @@ func Load() Config {
 	}
 
 	cfg := Config{
-		Port:      env("PORT", "8080"),
-		JWTSecret: env("JWT_SECRET", "golangotpauthentication"),
+		Port:        env("PORT", "8080"),
+		JWTSecret:   os.Getenv("JWT_SECRET"),
+		AdminPhones: envList("ADMIN_PHONES"),
+
+		JWTPrivateKeyFile: strings.TrimSpace(os.Getenv("JWT_PRIVATE_KEY_FILE")),
+		JWTKeyID:          strings.TrimSpace(os.Getenv("JWT_KEY_ID")),
+		JWTPublicKeyFiles: envList("JWT_PUBLIC_KEY_FILES"),
+		JWTIssuer:         env("JWT_ISSUER", "otp-service"),
+		JWTAudience:       env("JWT_AUDIENCE", "otp-service"),
+		JWTLeeway:         envDuration("JWT_LEEWAY", 30*time.Second),
 
 		UseDB:    envBool("USE_DB", true),
 		UseRedis: envBool("USE_REDIS", true),
@@ -57,6 +159,14 @@ func Load() Config {
 		DatabaseURL: strings.TrimSpace(os.Getenv("DATABASE_URL")),
 		RedisURL:    strings.TrimSpace(os.Getenv("REDIS_URL")),
 
+		DBAutoMigrate: envBool("DB_AUTO_MIGRATE", true),
+
+		MemorySweepInterval: envDuration("MEMORY_SWEEP_INTERVAL", time.Minute),
+		MemoryMaxKeys:       envInt("MEMORY_MAX_KEYS", 100_000),
+
+		ShutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DebugVars:       envBool("DEBUG_VARS", false),
+
 		PGUser:     env("POSTGRES_USER", "otp"),
 		PGPassword: env("POSTGRES_PASSWORD", "otp"),
 		PGDB:       env("POSTGRES_DB", "otp"),
@@ -69,9 +179,70 @@ func Load() Config {
 
 		// Tunables (durations accept Go format: 30s, 2m, 1h)
 		OTPTTL:          envDuration("OTP_TTL", 2*time.Minute),
+		OTPLength:       envInt("OTP_LENGTH", 6),
+		OTPAlphabet:     env("OTP_ALPHABET", "numeric"),
+		OTPPurposeTTLs:  envList("OTP_PURPOSE_TTLS"),
+		OTPMaxAttempts:  envInt("OTP_MAX_ATTEMPTS", 5),
 		RateLimitMax:    envInt("RATE_LIMIT_MAX", 3),
 		RateLimitWindow: envDuration("RATE_LIMIT_WINDOW", 10*time.Minute),
-		TokenTTL:        envDuration("TOKEN_TTL", 24*time.Hour),
+		TokenTTL:        envDuration("TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		RefreshPruneInterval:    envDuration("REFRESH_PRUNE_INTERVAL", time.Hour),
+		RefreshRevokedRetention: envDuration("REFRESH_REVOKED_RETENTION", 24*time.Hour),
+
+		RateLimitIPMax:        envInt("RATE_LIMIT_IP_MAX", 10),
+		RateLimitIPWindow:     envDuration("RATE_LIMIT_IP_WINDOW", 10*time.Minute),
+		RateLimitIPv4Prefix:   envInt("RATE_LIMIT_IPV4_PREFIX", 32),
+		RateLimitIPv6Prefix:   envInt("RATE_LIMIT_IPV6_PREFIX", 64),
+		RateLimitGlobalMax:    envInt("RATE_LIMIT_GLOBAL_MAX", 300),
+		RateLimitGlobalWindow: envDuration("RATE_LIMIT_GLOBAL_WINDOW", time.Minute),
+
+		ChallengeKind:          strings.ToLower(env("CHALLENGE_KIND", "pow")),
+		ChallengePhoneAfter:    envInt("CHALLENGE_PHONE_AFTER", 2),
+		ChallengeIPAfter:       envInt("CHALLENGE_IP_AFTER", 5),
+		ChallengeTTL:           envDuration("CHALLENGE_TTL", 2*time.Minute),
+		ChallengeIssueMax:      envInt("CHALLENGE_ISSUE_MAX", 20),
+		ChallengeIssueWindow:   envDuration("CHALLENGE_ISSUE_WINDOW", 10*time.Minute),
+		ChallengePoWDifficulty: envInt("CHALLENGE_POW_DIFFICULTY", 20),
+
+		CaptchaProvider:  strings.ToLower(env("CAPTCHA_PROVIDER", "siteverify")),
+		CaptchaVerifyURL: strings.TrimSpace(os.Getenv("CAPTCHA_VERIFY_URL")),
+		CaptchaSiteKey:   strings.TrimSpace(os.Getenv("CAPTCHA_SITE_KEY")),
+		CaptchaSecret:    os.Getenv("CAPTCHA_SECRET"),
+		CaptchaTimeout:   envDuration("CAPTCHA_TIMEOUT", 5*time.Second),
+		CaptchaFakeToken: env("CAPTCHA_FAKE_TOKEN", "pass"),
+
+		ProxyHeader:    strings.TrimSpace(os.Getenv("PROXY_HEADER")),
+		TrustedProxies: envList("TRUSTED_PROXIES"),
+
+		OTPSecret:          os.Getenv("OTP_SECRET"),
+		OTPPreviousSecrets: envList("OTP_PREVIOUS_SECRETS"),
+		OTPAcceptLegacy:    envBool("OTP_ACCEPT_LEGACY_PLAINTEXT", false),
+		OTPResendCooldowns: envDurations("OTP_RESEND_COOLDOWNS", []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute}),
+		OTPResendReset:     envDuration("OTP_RESEND_RESET", time.Hour),
+
+		TOTPIssuer:      env("TOTP_ISSUER", "OTP Service"),
+		TOTPKey:         os.Getenv("TOTP_ENCRYPTION_KEY"),
+		TOTPPrevKeys:    envList("TOTP_PREVIOUS_ENCRYPTION_KEYS"),
+		MFATokenTTL:     envDuration("MFA_TOKEN_TTL", 5*time.Minute),
+		StepUpTokenTTL:  envDuration("STEP_UP_TOKEN_TTL", 5*time.Minute),
+		TOTPMaxAttempts: envInt("TOTP_MAX_ATTEMPTS", 5),
+		TOTPWindow:      envDuration("TOTP_WINDOW", 5*time.Minute),
+
+		OTPSender:         strings.ToLower(env("OTP_SENDER", "console")),
+		OTPOutboxPath:     env("OTP_OUTBOX_PATH", "otp_outbox.jsonl"),
+		SMSWebhookURL:     strings.TrimSpace(os.Getenv("SMS_WEBHOOK_URL")),
+		SMSWebhookToken:   os.Getenv("SMS_WEBHOOK_TOKEN"),
+		SMSWebhookTimeout: envDuration("SMS_WEBHOOK_TIMEOUT", 5*time.Second),
+
+		PhoneDefaultRegion:    env("PHONE_DEFAULT_REGION", "IR"),
+		PhoneAllowedCountries: envList("PHONE_ALLOWED_COUNTRIES"),
+		PhoneDeniedCountries:  envList("PHONE_DENIED_COUNTRIES"),
+
+		PhoneBlocklist:   envList("PHONE_BLOCKLIST"),
+		PhoneAllowlist:   envList("PHONE_ALLOWLIST"),
+		PhoneTestNumbers: envList("PHONE_TEST_NUMBERS"),
 	}
 
 	// Toggles force in-memory by blanking URLs
@@ -97,6 +268,40 @@ func Load() Config {
 	return cfg
 }
 
+// publicJWTSecret is the JWT_SECRET older versions defaulted to and .env.example shipped.
+const publicJWTSecret = "golangotpauthentication"
+
+// Validate rejects settings the server must not start with.
+func (c Config) Validate() error {
+	if c.ProxyHeader != "" && len(c.TrustedProxies) == 0 {
+		return errors.New("PROXY_HEADER requires TRUSTED_PROXIES: otherwise any client can spoof its IP")
+	}
+	if c.JWTPrivateKeyFile == "" {
+		if c.JWTSecret == "" {
+			return errors.New("JWT_SECRET is required unless JWT_PRIVATE_KEY_FILE is set")
+		}
+		if c.JWTSecret == publicJWTSecret {
+			return errors.New("JWT_SECRET still has the published example value: anyone could sign admin tokens")
+		}
+	}
+	if c.OTPMaxAttempts <= 0 {
+		return errors.New("OTP_MAX_ATTEMPTS must be at least 1: a code that never locks can be brute-forced")
+	}
+	if c.OTPSecret == "" {
+		return errors.New("OTP_SECRET is required")
+	}
+	if c.OTPSecret == c.JWTSecret {
+		return errors.New("OTP_SECRET must differ from JWT_SECRET")
+	}
+	if c.TOTPKey == "" {
+		return errors.New("TOTP_ENCRYPTION_KEY is required")
+	}
+	if c.TOTPKey == c.JWTSecret || c.TOTPKey == c.OTPSecret {
+		return errors.New("TOTP_ENCRYPTION_KEY must differ from JWT_SECRET and OTP_SECRET")
+	}
+	return nil
+}
+
 func env(k, d string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -124,6 +329,18 @@ func envBool(k string, d bool) bool {
 	}
 	return d
 }
+
+// envList splits a comma separated value, dropping empty items.
+func envList(k string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(k), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func envDuration(k string, d time.Duration) time.Duration {
 	if v, ok := os.LookupEnv(k); ok && strings.TrimSpace(v) != "" {
 		if dur, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
@@ -134,6 +351,24 @@ func envDuration(k string, d time.Duration) time.Duration {
 	return d
 }
 
+// envDurations reads a comma-separated list of durations; any invalid entry keeps the default.
+func envDurations(k string, d []time.Duration) []time.Duration {
+	v := os.Getenv(k)
+	if strings.TrimSpace(v) == "" {
+		return d
+	}
+	var out []time.Duration
+	for _, s := range strings.Split(v, ",") {
+		dur, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || dur < 0 {
+			log.Printf("warning: invalid durations for %s=%q (use e.g. 30s,1m,2m); using default %v", k, v, d)
+			return d
+		}
+		out = append(out, dur)
+	}
+	return out
+}
+
 // Minimal percent-escape for URL segments
 func urlEscape(s string) string {
 	r := strings.NewReplacer(" ", "%20", "#", "%23", "@", "%40", ":", "%3A", "/", "%2F", "?", "%3F", "&", "%26", "=", "%3D")
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Config{JWTSecret: "jwt", OTPSecret: "otp", TOTPKey: "totp", OTPMaxAttempts: 5}
+	for _, tc := range []struct {
+		name   string
+		mutate func(*Config)
+		err    string
+	}{
+		{"valid", func(*Config) {}, ""},
+		{"proxy without trusted", func(c *Config) { c.ProxyHeader = "X-Real-IP" }, "TRUSTED_PROXIES"},
+		{"missing jwt secret", func(c *Config) { c.JWTSecret = "" }, "JWT_SECRET is required"},
+		{"published jwt secret", func(c *Config) { c.JWTSecret = publicJWTSecret }, "published example value"},
+		{"private key without jwt secret", func(c *Config) { c.JWTSecret, c.JWTPrivateKeyFile = "", "key.pem" }, ""},
+		{"unlimited otp attempts", func(c *Config) { c.OTPMaxAttempts = 0 }, "OTP_MAX_ATTEMPTS"},
+		{"negative otp attempts", func(c *Config) { c.OTPMaxAttempts = -1 }, "OTP_MAX_ATTEMPTS"},
+		{"missing otp secret", func(c *Config) { c.OTPSecret = "" }, "OTP_SECRET is required"},
+		{"otp secret reuses jwt", func(c *Config) { c.OTPSecret = c.JWTSecret }, "differ from JWT_SECRET"},
+		{"missing totp key", func(c *Config) { c.TOTPKey = "" }, "TOTP_ENCRYPTION_KEY is required"},
+		{"totp key reuses otp secret", func(c *Config) { c.TOTPKey = c.OTPSecret }, "TOTP_ENCRYPTION_KEY must differ"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := valid
+			tc.mutate(&cfg)
+			err := cfg.Validate()
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Validate() = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshToken is the server-side record of an opaque refresh token.
+// Every rotation creates a new token in the same family; presenting an
+// already-used token revokes the whole family.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string // shared by all tokens rotated from one login
+	UserID    string
+	TokenHash string // sha256 of the opaque token, the token itself is never stored
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time // set once rotated
+	RevokedAt *time.Time
+}
+
+// NewOpaque returns a random refresh token and the hash to persist.
+func NewOpaque() (tok, hash string, err error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", "", err
+	}
+	tok = base64.RawURLEncoding.EncodeToString(b[:])
+	return tok, Hash(tok), nil
+}
+
+// Hash is the lookup key stored for tok.
+func Hash(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,10 @@
+package user
+
+import "errors"
+
+// Errors returned by every Repository implementation; anything else means the
+// store itself failed.
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrConflict = errors.New("user already exists") // duplicate id or phone
+)
@@ -2,8 +2,29 @@ package user
 
 import "time"
 
+// Roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID           string    `json:"id"`
-	Phone        string    `json:"phone"`
+	Phone        string    `json:"phone"` // E.164, see package phone
 	RegisteredAt time.Time `json:"registered_at"`
+	Role         string    `json:"role"` // RoleUser or RoleAdmin
+	// Created by a login with a test number's fixed code (see phonelist.KindTest)
+	TestAccount bool `json:"test_account,omitempty"`
+
+	// Optional profile, editable by the user; "" means unset
+	DisplayName string `json:"display_name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Locale      string `json:"locale,omitempty"` // BCP 47 tag, e.g. "fa-IR"
+
+	// TOTP second factor: a secret with TOTPEnabled=false is a pending enrollment.
+	// The secret is stored encrypted, see totp.Cipher.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// Time step of the last accepted TOTP code; codes at or below it are replays
+	TOTPLastStep int64 `json:"-"`
 }
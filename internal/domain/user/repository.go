@@ -8,9 +8,33 @@ type ListFilter struct {
 	Offset int
 }
 
+// ProfileUpdate lists the profile fields to change; nil fields are left as they are.
+type ProfileUpdate struct {
+	DisplayName *string
+	Email       *string
+	Locale      *string
+}
+
 type Repository interface {
 	Create(ctx context.Context, u *User) error
 	GetByID(ctx context.Context, id string) (*User, error)
 	GetByPhone(ctx context.Context, phone string) (*User, error)
+	// FindOrCreateByPhone atomically returns the user owning u.Phone, creating it
+	// from u when there is none. Concurrent callers always get the same user.
+	FindOrCreateByPhone(ctx context.Context, u *User) (*User, error)
 	List(ctx context.Context, f ListFilter) (users []User, total int, err error)
+
+	// SetTOTP stores the user's authenticator secret; ("", false) removes it.
+	SetTOTP(ctx context.Context, id, secret string, enabled bool) error
+	// AdvanceTOTPStep records step as the last accepted TOTP step if it is above the
+	// stored one, atomically; false means the code was already used (a replay), and
+	// ErrNotFound that there is no such user.
+	AdvanceTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	SetRole(ctx context.Context, id, role string) error
+	// UpdateProfile applies p and returns the updated user.
+	UpdateProfile(ctx context.Context, id string, p ProfileUpdate) (*User, error)
+	// SetPhone moves the user to phone (E.164); ErrConflict if another user has it.
+	SetPhone(ctx context.Context, id, phone string) (*User, error)
+	// Delete removes the user (and, in Postgres, its refresh tokens).
+	Delete(ctx context.Context, id string) error
 }
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+)
+
+// AdminHandler serves support/ops operations.
+type AdminHandler struct {
+	Users         user.Repository
+	RefreshTokens token.Repository
+	Revocations   revocation.Store
+
+	// request-otp state, shared with AuthHandler
+	Phones  *phone.Normalizer
+	OTP     otp.Service
+	Limiter otp.Limiter
+	Limits  OTPLimits
+
+	PhoneList *phonelist.List
+}
+
+type SetRoleReq struct {
+	Role string `json:"role"`
+}
+
+type PhoneListEntryReq struct {
+	Pattern string `json:"pattern"`        // a number (any accepted format) or an E.164 prefix like "+98912*"
+	Kind    string `json:"kind"`           // block | allow | test
+	Code    string `json:"code,omitempty"` // test numbers: the fixed code
+	Note    string `json:"note,omitempty"`
+}
+
+// LimitState is a request-otp rate limit layer; a zero Limit means the layer is disabled.
+type LimitState struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Blocked   bool      `json:"blocked"`  // the next request-otp would get 429
+	ResetAt   time.Time `json:"reset_at"` // when the oldest counted request frees a slot
+}
+
+// PendingOTPResp describes an outstanding code; the code itself is never exposed.
+type PendingOTPResp struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Attempts  int       `json:"attempts"` // wrong guesses so far
+	Locked    bool      `json:"locked"`   // attempts exhausted, verify-otp fails until cancelled or expired
+}
+
+type PhoneOTPStateResp struct {
+	Phone     string          `json:"phone"`
+	Purpose   string          `json:"purpose"`   // of Pending and ResendIn; RateLimit covers all purposes
+	Pending   *PendingOTPResp `json:"pending"`   // null when no code is outstanding
+	ResendIn  int             `json:"resend_in"` // seconds of resend cooldown left
+	RateLimit LimitState      `json:"rate_limit"`
+}
+
+type IPOTPStateResp struct {
+	Subnet    string     `json:"subnet"` // the client subnet the limit applies to
+	RateLimit LimitState `json:"rate_limit"`
+}
+
+// RevokeSessions godoc
+// @Summary   Revoke all sessions of a user
+// @Description Invalidates every access token issued so far and all refresh tokens of the user.
+// @Tags      admin
+// @Produce   json
+// @Param     id path string true "User ID"
+// @Success   200 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   404 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/users/{id}/revoke-sessions [post]
+func (h *AdminHandler) RevokeSessions(c *fiber.Ctx) error {
+	id := c.Params("id")
+	ctx := context.Background()
+	u, err := h.Users.GetByID(ctx, id)
+	if err != nil {
+		return userError(c, err)
+	}
+	now := time.Now().UTC()
+	if err := h.Revocations.RevokeUser(ctx, u.ID, now); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "revoke failed"})
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, u.ID, now); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "revoke failed"})
+	}
+	return c.JSON(fiber.Map{"message": "sessions revoked"})
+}
+
+// SetRole godoc
+// @Summary   Change a user's role
+// @Description Sets the role (user|admin). Existing access tokens of the user are revoked so the new role applies at once.
+// @Tags      admin
+// @Accept    json
+// @Produce   json
+// @Param     id path string true "User ID"
+// @Param     payload body SetRoleReq true "Role payload"
+// @Success   200 {object} user.User
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   404 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/users/{id}/role [put]
+func (h *AdminHandler) SetRole(c *fiber.Ctx) error {
+	var req SetRoleReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if req.Role != user.RoleUser && req.Role != user.RoleAdmin {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid role"})
+	}
+	ctx := context.Background()
+	u, err := h.Users.GetByID(ctx, c.Params("id"))
+	if err != nil {
+		return userError(c, err)
+	}
+	if err := h.Users.SetRole(ctx, u.ID, req.Role); err != nil {
+		return userError(c, err)
+	}
+	// role is baked into access tokens: force a refresh
+	if err := h.Revocations.RevokeUser(ctx, u.ID, time.Now().UTC()); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "revoke failed"})
+	}
+	u.Role = req.Role
+	return c.JSON(u)
+}
+
+// GetPhoneOTP godoc
+// @Summary   Inspect the OTP state of a phone
+// @Description Shows the phone's request-otp rate limit, resend cooldown and whether a code is pending (never the code).
+// @Tags      admin
+// @Produce   json
+// @Param     phone path string true "Phone number (URL-encoded, any accepted format)"
+// @Param     purpose query string false "OTP purpose (default login)"
+// @Success   200 {object} PhoneOTPStateResp
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   500 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/otp/phones/{phone} [get]
+func (h *AdminHandler) GetPhoneOTP(c *fiber.Ctx) error {
+	p, err := h.phoneParam(c)
+	if err != nil {
+		return phoneError(c, err)
+	}
+	purpose, ok := otpPurpose(c.Query("purpose"))
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "unknown purpose"})
+	}
+	ctx := context.Background()
+	pending, err := h.OTP.Pending(ctx, purpose, p)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "otp error"})
+	}
+	wait, err := h.OTP.Cooldown(ctx, purpose, p)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "otp error"})
+	}
+	limit, err := h.limitState(ctx, phoneLimitKey(p), h.Limits.Phone)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "rate limit error"})
+	}
+	resp := PhoneOTPStateResp{Phone: p, Purpose: purpose, ResendIn: ceilSeconds(wait), RateLimit: limit}
+	if pending != nil {
+		resp.Pending = &PendingOTPResp{
+			ExpiresAt: time.Now().Add(pending.ExpiresIn).UTC(),
+			Attempts:  pending.Attempts,
+			Locked:    pending.Locked,
+		}
+	}
+	return c.JSON(resp)
+}
+
+// CancelPhoneOTP godoc
+// @Summary   Cancel the pending OTP of a phone
+// @Description Drops the outstanding code (e.g. locked after too many guesses) and restarts the resend cooldown, so the user can request a new one at once.
+// @Tags      admin
+// @Produce   json
+// @Param     phone path string true "Phone number (URL-encoded, any accepted format)"
+// @Param     purpose query string false "OTP purpose (default login)"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   500 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/otp/phones/{phone} [delete]
+func (h *AdminHandler) CancelPhoneOTP(c *fiber.Ctx) error {
+	p, err := h.phoneParam(c)
+	if err != nil {
+		return phoneError(c, err)
+	}
+	purpose, ok := otpPurpose(c.Query("purpose"))
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "unknown purpose"})
+	}
+	cancelled, err := h.OTP.Cancel(context.Background(), purpose, p)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "otp error"})
+	}
+	if !cancelled {
+		return c.JSON(fiber.Map{"message": "no pending otp, cooldown reset"})
+	}
+	return c.JSON(fiber.Map{"message": "otp cancelled"})
+}
+
+// ResetPhoneRateLimit godoc
+// @Summary   Reset the request-otp rate limit of a phone
+// @Tags      admin
+// @Produce   json
+// @Param     phone path string true "Phone number (URL-encoded, any accepted format)"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   500 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/otp/phones/{phone}/rate-limit [delete]
+func (h *AdminHandler) ResetPhoneRateLimit(c *fiber.Ctx) error {
+	p, err := h.phoneParam(c)
+	if err != nil {
+		return phoneError(c, err)
+	}
+	if err := h.Limiter.Reset(context.Background(), phoneLimitKey(p)); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "rate limit error"})
+	}
+	return c.JSON(fiber.Map{"message": "rate limit reset"})
+}
+
+// GetIPRateLimit godoc
+// @Summary   Inspect the request-otp rate limit of a client IP
+// @Description The limit applies to the IP's subnet (see RATE_LIMIT_IPV4_PREFIX / RATE_LIMIT_IPV6_PREFIX).
+// @Tags      admin
+// @Produce   json
+// @Param     ip path string true "Client IP address"
+// @Success   200 {object} IPOTPStateResp
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   500 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/otp/ips/{ip} [get]
+func (h *AdminHandler) GetIPRateLimit(c *fiber.Ctx) error {
+	subnet, ok := h.subnetParam(c)
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid ip"})
+	}
+	limit, err := h.limitState(context.Background(), ipLimitKey(subnet), h.Limits.IP)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "rate limit error"})
+	}
+	return c.JSON(IPOTPStateResp{Subnet: subnet, RateLimit: limit})
+}
+
+// ResetIPRateLimit godoc
+// @Summary   Reset the request-otp rate limit of a client IP
+// @Description Resets the whole subnet the IP belongs to.
+// @Tags      admin
+// @Produce   json
+// @Param     ip path string true "Client IP address"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   500 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/otp/ips/{ip}/rate-limit [delete]
+func (h *AdminHandler) ResetIPRateLimit(c *fiber.Ctx) error {
+	subnet, ok := h.subnetParam(c)
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid ip"})
+	}
+	if err := h.Limiter.Reset(context.Background(), ipLimitKey(subnet)); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "rate limit error"})
+	}
+	return c.JSON(fiber.Map{"message": "rate limit reset", "subnet": subnet})
+}
+
+// ListPhoneList godoc
+// @Summary   List blocked, allowed and test numbers
+// @Description Entries from config (source "config", read-only) and from this API (source "admin").
+// @Tags      admin
+// @Produce   json
+// @Success   200 {array} phonelist.Entry
+// @Failure   403 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/phone-list [get]
+func (h *AdminHandler) ListPhoneList(c *fiber.Ctx) error {
+	entries, err := h.PhoneList.Entries(context.Background())
+	if err != nil {
+		return phoneListError(c, err)
+	}
+	return c.JSON(entries)
+}
+
+// PutPhoneListEntry godoc
+// @Summary   Add or replace a phone list entry
+// @Description block: request-otp and verify-otp answer 403 "phone blocked". allow: exception inside a blocked prefix. test: exact number whose code is always `code` and never sent.
+// @Description The most specific entry wins (an exact number over a prefix, a longer prefix over a shorter one).
+// @Description A phone that already belongs to a user cannot become a test number (409), since its fixed code would log anyone in as that user; an existing test number's code can still be changed.
+// @Tags      admin
+// @Accept    json
+// @Produce   json
+// @Param     payload body PhoneListEntryReq true "Entry"
+// @Success   200 {object} phonelist.Entry
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   409 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/phone-list [put]
+func (h *AdminHandler) PutPhoneListEntry(c *fiber.Ctx) error {
+	var req PhoneListEntryReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	pattern, err := h.listPattern(req.Pattern)
+	if err != nil {
+		return phoneListError(c, err)
+	}
+	if req.Kind == phonelist.KindTest {
+		taken, err := h.ownedPhone(context.Background(), pattern)
+		if err != nil {
+			return userError(c, err)
+		}
+		if taken {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "phone belongs to a user"})
+		}
+	}
+	e, err := h.PhoneList.Put(context.Background(), phonelist.Entry{
+		Pattern: pattern,
+		Kind:    req.Kind,
+		Code:    req.Code,
+		Note:    strings.TrimSpace(req.Note),
+	})
+	if err != nil {
+		return phoneListError(c, err)
+	}
+	return c.JSON(e)
+}
+
+// DeletePhoneListEntry godoc
+// @Summary   Remove a phone list entry
+// @Tags      admin
+// @Produce   json
+// @Param     pattern path string true "Number or prefix (URL-encoded, e.g. %2B98912*)"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   404 {object} map[string]string
+// @Failure   409 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /admin/phone-list/{pattern} [delete]
+func (h *AdminHandler) DeletePhoneListEntry(c *fiber.Ctx) error {
+	raw, err := url.PathUnescape(c.Params("pattern"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid pattern"})
+	}
+	pattern, err := h.listPattern(raw)
+	if err != nil {
+		return phoneListError(c, err)
+	}
+	found, err := h.PhoneList.Delete(context.Background(), pattern)
+	if err != nil {
+		return phoneListError(c, err)
+	}
+	if !found {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"message": "entry removed"})
+}
+
+// ownedPhone reports whether a real user has phone, one whose account was not created
+// through a test number's fixed code in the first place.
+func (h *AdminHandler) ownedPhone(ctx context.Context, phone string) (bool, error) {
+	u, err := h.Users.GetByPhone(ctx, phone)
+	if errors.Is(err, user.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !u.TestAccount, nil
+}
+
+// listPattern normalizes exact numbers to E.164; prefixes ("+98912*") are kept as typed.
+func (h *AdminHandler) listPattern(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasSuffix(raw, "*") {
+		return raw, nil
+	}
+	p, err := h.Phones.Normalize(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", phonelist.ErrInvalid, err)
+	}
+	return p, nil
+}
+
+// phoneParam normalizes the :phone path parameter ("+" may arrive as %2B).
+func (h *AdminHandler) phoneParam(c *fiber.Ctx) (string, error) {
+	raw, err := url.PathUnescape(c.Params("phone"))
+	if err != nil {
+		return "", phone.ErrInvalid
+	}
+	return h.Phones.Normalize(raw)
+}
+
+// subnetParam maps the :ip path parameter to the subnet its limit is kept under.
+func (h *AdminHandler) subnetParam(c *fiber.Ctx) (string, bool) {
+	raw, err := url.PathUnescape(c.Params("ip"))
+	if err != nil {
+		return "", false
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return "", false
+	}
+	return h.Limits.subnet(addr), true
+}
+
+func (h *AdminHandler) limitState(ctx context.Context, key string, p otp.Policy) (LimitState, error) {
+	res, err := h.Limiter.Peek(ctx, key, p)
+	if err != nil {
+		return LimitState{}, err
+	}
+	return LimitState{Limit: res.Limit, Remaining: res.Remaining, Blocked: !res.Allowed, ResetAt: res.ResetAt.UTC()}, nil
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	memoryotp "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	memphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/memory"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+)
+
+func TestPutTestNumberOfExistingUser(t *testing.T) {
+	ctx := context.Background()
+	phones, _ := phone.NewNormalizer("IR", nil, nil)
+	list, _ := phonelist.New(memphonelist.NewStore(), nil, otp.Format{})
+	users := memory.NewUserRepo()
+	h := &AdminHandler{Users: users, Phones: phones, PhoneList: list}
+	app := fiber.New()
+	app.Put("/phone-list", h.PutPhoneListEntry)
+	put := func(body string) int {
+		t.Helper()
+		req := httptest.NewRequest("PUT", "/phone-list", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if err := users.Create(ctx, &user.User{ID: "admin", Phone: "+989121111111", Role: user.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	// any accepted format of the number is caught
+	for _, p := range []string{"+989121111111", "09121111111"} {
+		if status := put(`{"pattern":"` + p + `","kind":"test","code":"424242"}`); status != http.StatusConflict {
+			t.Fatalf("test entry for a user's phone %s = %d, want 409", p, status)
+		}
+	}
+	if code, ok, _ := list.TestCode(ctx, "+989121111111"); ok {
+		t.Fatalf("user's phone got test code %s", code)
+	}
+	// blocking a user's phone is still fine
+	if status := put(`{"pattern":"+989121111111","kind":"block"}`); status != http.StatusOK {
+		t.Fatalf("block entry = %d, want 200", status)
+	}
+
+	// a test number whose account was created through it can get a new code
+	if status := put(`{"pattern":"+989990000001","kind":"test","code":"424242"}`); status != http.StatusOK {
+		t.Fatalf("new test number = %d, want 200", status)
+	}
+	if err := users.Create(ctx, &user.User{ID: "qa", Phone: "+989990000001", Role: user.RoleUser, TestAccount: true}); err != nil {
+		t.Fatal(err)
+	}
+	if status := put(`{"pattern":"+989990000001","kind":"test","code":"434343"}`); status != http.StatusOK {
+		t.Fatalf("changing a test number's code = %d, want 200", status)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	ctx := t.Context()
+	keys := jwtutil.NewHMACKeySet("secret")
+	signer := &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"}
+	verifier := jwtutil.NewVerifier(keys, "iss", "aud", 0)
+	phones, err := phone.NewNormalizer("IR", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := memory.NewUserRepo()
+	refresh := memory.NewRefreshRepo(ctx, 0)
+	h := &AdminHandler{
+		Users:         users,
+		RefreshTokens: refresh,
+		Revocations:   memrevocation.NewStore(time.Hour),
+		Phones:        phones,
+		OTP: memoryotp.NewManager(ctx, otp.Options{
+			TTL:             time.Minute,
+			ResendCooldowns: []time.Duration{time.Minute},
+			MaxAttempts:     3,
+			Hasher:          otp.NewHasher("otp-secret", nil),
+			Sender:          nopSender{},
+		}, memoryotp.JanitorOptions{}),
+		Limiter: memoryotp.NewLimiter(ctx, memoryotp.JanitorOptions{}),
+		Limits: OTPLimits{
+			Phone:      otp.Policy{Limit: 2, Window: time.Minute},
+			IP:         otp.Policy{Limit: 2, Window: time.Minute},
+			IPv4Prefix: 24,
+			IPv6Prefix: 64,
+		},
+	}
+	// wired as in httpapi.New
+	app := fiber.New()
+	admin := app.Group("/admin", middleware.Auth(verifier, h.Revocations, users), middleware.RequireRole(user.RoleAdmin))
+	admin.Post("/users/:id/revoke-sessions", h.RevokeSessions)
+	admin.Put("/users/:id/role", h.SetRole)
+	admin.Get("/otp/phones/:phone", h.GetPhoneOTP)
+	admin.Delete("/otp/phones/:phone", h.CancelPhoneOTP)
+	admin.Delete("/otp/phones/:phone/rate-limit", h.ResetPhoneRateLimit)
+	admin.Get("/otp/ips/:ip", h.GetIPRateLimit)
+	admin.Delete("/otp/ips/:ip/rate-limit", h.ResetIPRateLimit)
+
+	for _, u := range []user.User{
+		{ID: "root", Phone: "+989120000000", Role: user.RoleAdmin},
+		{ID: "alice", Phone: "+989121111111", Role: user.RoleUser},
+		{ID: "bob", Phone: "+989122222222", Role: user.RoleUser},
+	} {
+		if err := users.Create(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	access := func(id, role string) string {
+		tok, err := signer.Access(id, role, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	root, alice, bob := access("root", user.RoleAdmin), access("alice", user.RoleUser), access("bob", user.RoleUser)
+	call := func(method, path, tok, body string) (int, map[string]any) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	const phonePath = "/admin/otp/phones/09121111111" // national format is accepted
+
+	t.Run("authorization", func(t *testing.T) {
+		for _, r := range []struct{ method, path, body string }{
+			{"POST", "/admin/users/bob/revoke-sessions", ""},
+			{"PUT", "/admin/users/alice/role", `{"role":"admin"}`},
+			{"GET", phonePath, ""},
+			{"DELETE", phonePath, ""},
+			{"DELETE", phonePath + "/rate-limit", ""},
+			{"GET", "/admin/otp/ips/10.0.0.1", ""},
+			{"DELETE", "/admin/otp/ips/10.0.0.1/rate-limit", ""},
+		} {
+			if status, _ := call(r.method, r.path, "", r.body); status != http.StatusUnauthorized {
+				t.Errorf("%s %s without a token = %d, want 401", r.method, r.path, status)
+			}
+			if status, _ := call(r.method, r.path, alice, r.body); status != http.StatusForbidden {
+				t.Errorf("%s %s as a user = %d, want 403", r.method, r.path, status)
+			}
+		}
+		if u, _ := users.GetByID(ctx, "alice"); u.Role != user.RoleUser {
+			t.Fatalf("a user promoted herself: %+v", u)
+		}
+	})
+
+	t.Run("revoke sessions", func(t *testing.T) {
+		if status, _ := call("POST", "/admin/users/nobody/revoke-sessions", root, ""); status != http.StatusNotFound {
+			t.Errorf("unknown user = %d, want 404", status)
+		}
+		if err := refresh.Create(ctx, &token.RefreshToken{ID: "rt", FamilyID: "rt", UserID: "bob", TokenHash: "bob-session", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := call("POST", "/admin/users/bob/revoke-sessions", root, ""); status != http.StatusOK {
+			t.Fatalf("revoke-sessions = %d, want 200", status)
+		}
+		if rt, _ := refresh.GetByHash(ctx, "bob-session"); rt == nil || rt.RevokedAt == nil {
+			t.Errorf("refresh token not revoked: %+v", rt)
+		}
+		if status, _ := call("GET", phonePath, bob, ""); status != http.StatusUnauthorized {
+			t.Errorf("access token after revoke-sessions = %d, want 401", status)
+		}
+		if status, _ := call("GET", phonePath, root, ""); status != http.StatusOK {
+			t.Errorf("other users' tokens = %d, want 200", status)
+		}
+	})
+
+	t.Run("set role", func(t *testing.T) {
+		for _, tc := range []struct {
+			name, id, body string
+			want           int
+		}{
+			{"unknown user", "nobody", `{"role":"admin"}`, http.StatusNotFound},
+			{"unknown role", "alice", `{"role":"owner"}`, http.StatusBadRequest},
+			{"no role", "alice", `{}`, http.StatusBadRequest},
+			{"not json", "alice", `{`, http.StatusBadRequest},
+		} {
+			if status, _ := call("PUT", "/admin/users/"+tc.id+"/role", root, tc.body); status != tc.want {
+				t.Errorf("%s: set role = %d, want %d", tc.name, status, tc.want)
+			}
+		}
+		status, body := call("PUT", "/admin/users/alice/role", root, `{"role":"admin"}`)
+		if status != http.StatusOK || body["role"] != user.RoleAdmin {
+			t.Fatalf("set role = %d %v", status, body)
+		}
+		if u, _ := users.GetByID(ctx, "alice"); u.Role != user.RoleAdmin {
+			t.Errorf("stored role = %s", u.Role)
+		}
+		// her token still says "user": it is revoked so she picks up the role on refresh
+		if status, _ := call("GET", phonePath, alice, ""); status != http.StatusUnauthorized {
+			t.Errorf("access token from before the role change = %d, want 401", status)
+		}
+	})
+
+	t.Run("phone otp", func(t *testing.T) {
+		for _, path := range []string{"/admin/otp/phones/12", phonePath + "?purpose=nope"} {
+			if status, _ := call("GET", path, root, ""); status != http.StatusBadRequest {
+				t.Errorf("GET %s = %d, want 400", path, status)
+			}
+			if status, _ := call("DELETE", path, root, ""); status != http.StatusBadRequest {
+				t.Errorf("DELETE %s = %d, want 400", path, status)
+			}
+		}
+		if status, _ := call("DELETE", "/admin/otp/phones/12/rate-limit", root, ""); status != http.StatusBadRequest {
+			t.Errorf("reset of an invalid phone = %d, want 400", status)
+		}
+
+		status, body := call("GET", phonePath, root, "")
+		if status != http.StatusOK || body["phone"] != "+989121111111" || body["purpose"] != otp.PurposeLogin || body["pending"] != nil {
+			t.Fatalf("idle phone = %d %v", status, body)
+		}
+		if _, err := h.OTP.Generate(ctx, otp.PurposeLogin, "+989121111111"); err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			if _, err := h.Limiter.Allow(ctx, phoneLimitKey("+989121111111"), h.Limits.Phone); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, body = call("GET", phonePath, root, "")
+		pending, _ := body["pending"].(map[string]any)
+		limit, _ := body["rate_limit"].(map[string]any)
+		if pending == nil || pending["attempts"] != 0.0 || body["resend_in"].(float64) <= 0 || limit["blocked"] != true {
+			t.Fatalf("phone with a code = %v", body)
+		}
+		if _, body := call("GET", phonePath+"?purpose="+otp.PurposeDeleteAccount, root, ""); body["pending"] != nil {
+			t.Errorf("code of another purpose shown: %v", body)
+		}
+
+		if _, body := call("DELETE", phonePath, root, ""); body["message"] != "otp cancelled" {
+			t.Errorf("cancel = %v", body)
+		}
+		if _, body := call("DELETE", phonePath, root, ""); body["message"] != "no pending otp, cooldown reset" {
+			t.Errorf("second cancel = %v", body)
+		}
+		if status, _ := call("DELETE", phonePath+"/rate-limit", root, ""); status != http.StatusOK {
+			t.Errorf("reset = %d", status)
+		}
+		_, body = call("GET", phonePath, root, "")
+		limit, _ = body["rate_limit"].(map[string]any)
+		if body["pending"] != nil || body["resend_in"] != 0.0 || limit["blocked"] != false || limit["remaining"] != 2.0 {
+			t.Errorf("after cancel and reset = %v", body)
+		}
+	})
+
+	t.Run("ip rate limit", func(t *testing.T) {
+		for _, path := range []string{"/admin/otp/ips/nope", "/admin/otp/ips/nope/rate-limit"} {
+			method := "GET"
+			if strings.HasSuffix(path, "rate-limit") {
+				method = "DELETE"
+			}
+			if status, _ := call(method, path, root, ""); status != http.StatusBadRequest {
+				t.Errorf("%s %s = %d, want 400", method, path, status)
+			}
+		}
+		if _, err := h.Limiter.Allow(ctx, ipLimitKey("10.0.0.0/24"), h.Limits.IP); err != nil {
+			t.Fatal(err)
+		}
+		// any address of the subnet shows and resets its limit
+		status, body := call("GET", "/admin/otp/ips/10.0.0.200", root, "")
+		limit, _ := body["rate_limit"].(map[string]any)
+		if status != http.StatusOK || body["subnet"] != "10.0.0.0/24" || limit["remaining"] != 1.0 {
+			t.Fatalf("ip state = %d %v", status, body)
+		}
+		if _, body := call("DELETE", "/admin/otp/ips/10.0.0.7/rate-limit", root, ""); body["subnet"] != "10.0.0.0/24" {
+			t.Errorf("reset = %v", body)
+		}
+		_, body = call("GET", "/admin/otp/ips/10.0.0.1", root, "")
+		if limit, _ := body["rate_limit"].(map[string]any); limit["remaining"] != 2.0 {
+			t.Errorf("after reset = %v", body)
+		}
+	})
+}
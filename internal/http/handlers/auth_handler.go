@@ -2,116 +2,545 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
-	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
 	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
 	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
 	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+	"github.com/TheAmirMohammad/otp-service/internal/totp"
 )
 
 type AuthHandler struct {
-	OTP       otp.Service
-	Limiter   otp.Limiter
-	JWTSecret string
-	TokenTTL  time.Duration
-	Users     user.Repository
+	OTP      otp.Service
+	Limiter  otp.Limiter // shared by request-otp (Limits) and TOTP guesses (TOTPPolicy)
+	Limits   OTPLimits
+	Tokens   *jwtutil.Signer
+	Verifier *jwtutil.Verifier
+	TokenTTL time.Duration
+	Users    user.Repository
+	Phones   *phone.Normalizer // canonical E.164 + country policy
+
+	// Length and alphabet of issued codes; verify-otp rejects anything else early
+	OTPFormat otp.Format
+
+	// Blocked numbers/prefixes and test numbers with a fixed code
+	PhoneList *phonelist.List
+
+	// Asked for past the soft thresholds of Limits; nil disables them
+	Challenge challenge.Provider
+
+	// Opaque refresh tokens, rotated on every use
+	RefreshTokens token.Repository
+	RefreshTTL    time.Duration
+
+	// Phones (E.164) that are always given the admin role (bootstrap)
+	AdminPhones []string
+
+	// Access token denylist (logout)
+	Revocations revocation.Store
+
+	// TOTP second factor
+	MFATokenTTL time.Duration
+	TOTPPolicy  otp.Policy // caps TOTP guesses per user
+	TOTPCipher  *totp.Cipher
+
+	// Lifetime of the token verify-otp returns for purposes other than login
+	StepUpTokenTTL time.Duration
 }
 
 // DTOs (exported for Swagger)
 
 type VerifyOTPReq struct {
-	Phone string `json:"phone"`
-	OTP   string `json:"otp"`
+	Phone   string `json:"phone"`
+	OTP     string `json:"otp"`
+	Purpose string `json:"purpose,omitempty"` // login (default) | change_phone | delete_account
 }
 type AuthResp struct {
-	Token string    `json:"token"`
-	User  user.User `json:"user"`
+	Token        string    `json:"token"`
+	ExpiresIn    int64     `json:"expires_in"` // access token lifetime in seconds
+	RefreshToken string    `json:"refresh_token"`
+	User         user.User `json:"user"`
+}
+
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// MFAResp is returned by verify-otp instead of AuthResp when the user has TOTP enabled.
+type MFAResp struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// StepUpResp is returned by verify-otp for purposes other than login: proof that the
+// caller passed an OTP on the phone for that purpose, to hand to the endpoint
+// performing the action. It is bound to the caller and can be redeemed once.
+type StepUpResp struct {
+	StepUpToken string `json:"step_up_token"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds
+	Purpose     string `json:"purpose"`
+	Phone       string `json:"phone"`
+}
+
+// VerifyOTPResp only documents verify-otp's 200 body, which swag cannot express as
+// "one of": an AuthResp for purpose login, a StepUpResp for the other purposes.
+type VerifyOTPResp struct {
+	AuthResp
+	StepUpToken string `json:"step_up_token"` // StepUpResp only, like purpose and phone
+	Purpose     string `json:"purpose"`
+	Phone       string `json:"phone"`
+}
+
+type VerifyTOTPReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
 }
 
 type RequestOTPReq struct {
-	Phone string `json:"phone"`
+	Phone     string              `json:"phone"`
+	Purpose   string              `json:"purpose,omitempty"`   // login (default) | change_phone | delete_account
+	Challenge *challenge.Solution `json:"challenge,omitempty"` // answer to a 428 ChallengeResp
 }
 
-var phoneRx = regexp.MustCompile(`^[0-9+\-() ]{5,20}$`) // For Iran numbers it should be "^09\\d{9}$"
+type RequestOTPResp struct {
+	Message   string `json:"message"`
+	ExpiresIn int    `json:"expires_in"` // seconds the code stays valid
+	ResendIn  int    `json:"resend_in"`  // seconds until another code may be requested
+}
 
 // RequestOTP godoc
 // @Summary      Request OTP
-// @Description  Generates an OTP (printed in server logs). Rate limit: 3 per 10 minutes per phone. Expires in 2 minutes.
+// @Description  Generates an OTP and delivers it through the configured sender (console, file or SMS webhook). Rate limited per phone, per client IP/subnet and globally; every answer carries RateLimit-* headers and a 429 adds Retry-After.
+// @Description  Resending is throttled by a growing cooldown (e.g. 30s, 60s, 120s): resend_in tells the app how long to count down; too early answers 429 with scope "cooldown". Expires in 2 minutes.
+// @Description  Past a softer per-phone or per-IP threshold the answer is 428 with a challenge: a proof-of-work puzzle (find a nonce such that SHA-256(seed + ":" + nonce) starts with `difficulty` zero bits, send {"id","nonce"}) or a CAPTCHA (send {"token"}). Retry with the solution in "challenge".
 // @Tags         auth
 // @Accept       json
 // @Produce      json
+// @Description  The phone may be in international (+98...) or national (0912...) format; it is normalized to E.164.
 // @Param        payload body RequestOTPReq true "Phone payload"
-// @Success      200 {object} map[string]string
+// @Success      200 {object} RequestOTPResp
 // @Failure      400 {object} map[string]string
-// @Failure      429 {object} map[string]string
+// @Failure      403 {object} map[string]string
+// @Failure      428 {object} ChallengeResp
+// @Failure      429 {object} RateLimitResp
+// @Failure      503 {object} map[string]string
 // @Router       /auth/request-otp [post]
 func (h *AuthHandler) RequestOTP(c *fiber.Ctx) error {
 	var req RequestOTPReq
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
 	}
-	if !phoneRx.MatchString(req.Phone) {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid phone"})
+	purpose, ok := otpPurpose(req.Purpose)
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "unknown purpose"})
+	}
+	phoneNum, listed, err := h.checkPhone(c.Context(), req.Phone)
+	if err != nil {
+		return phoneError(c, err)
 	}
+	// test numbers never send anything: no challenge, no rate limit
+	isTest := listed != nil && listed.Kind == phonelist.KindTest
 
-	ok, err := h.Limiter.Allow(c.Context(), req.Phone)
+	// a resend during the cooldown must not eat into the rate limit budget
+	// (rate limits are shared by all purposes, cooldowns are per purpose)
+	wait, err := h.OTP.Cooldown(c.Context(), purpose, phoneNum)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "rate limit error"})
+		return otpError(c, "otp error", err)
 	}
-	if !ok {
-		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "rate limit: 3 per 10 minutes"})
+	if wait > 0 {
+		return resendCooldown(c, wait)
 	}
 
-	if _, err := h.OTP.Generate(c.Context(), req.Phone); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "otp error"})
+	if !isTest {
+		subnet := h.Limits.clientSubnet(c)
+		// a request the hard limits refuse anyway is not worth a challenge
+		scope, res, err := h.peekOTPRequest(c.Context(), phoneNum, subnet)
+		if err != nil {
+			return otpError(c, "rate limit error", err)
+		}
+		if scope != "" {
+			return rateLimited(c, "rate limit exceeded", scope, res)
+		}
+		scope, err = h.needsChallenge(c.Context(), phoneNum, subnet)
+		if err != nil {
+			return otpError(c, "rate limit error", err)
+		}
+		if scope != "" {
+			if req.Challenge == nil {
+				return h.challengeRequired(c, "challenge required", scope, subnet)
+			}
+			ok, err := h.Challenge.Verify(c.Context(), *req.Challenge, clientAddr(c).String())
+			if err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "challenge error"})
+			}
+			if !ok {
+				return h.challengeRequired(c, "invalid challenge solution", scope, subnet)
+			}
+		}
+
+		scope, res, err = h.allowOTPRequest(c.Context(), phoneNum, subnet)
+		if err != nil {
+			return otpError(c, "rate limit error", err)
+		}
+		if scope != "" {
+			return rateLimited(c, "rate limit exceeded", scope, res)
+		}
+		setRateLimitHeaders(c, res)
+	}
+
+	iss, err := h.OTP.Generate(c.Context(), purpose, phoneNum)
+	var cooldown *otp.CooldownError
+	if errors.As(err, &cooldown) { // lost a race with a concurrent request
+		return resendCooldown(c, cooldown.Wait)
 	}
-	return c.JSON(fiber.Map{"message": "otp generated (check server logs)"})
+	if err != nil {
+		return otpError(c, "otp error", err)
+	}
+	return c.JSON(RequestOTPResp{
+		Message:   "otp sent",
+		ExpiresIn: ceilSeconds(iss.ExpiresIn),
+		ResendIn:  ceilSeconds(iss.ResendIn),
+	})
+}
+
+// otpPurpose defaults an omitted purpose to login and reports whether it is known.
+func otpPurpose(raw string) (string, bool) {
+	if raw == "" {
+		return otp.PurposeLogin, true
+	}
+	return raw, otp.ValidPurpose(raw)
+}
+
+// checkPhone normalizes raw to E.164 and looks it up in the phone list; blocked
+// numbers fail with phonelist.ErrBlocked.
+func (h *AuthHandler) checkPhone(ctx context.Context, raw string) (string, *phonelist.Entry, error) {
+	p, err := h.Phones.Normalize(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	listed, err := h.PhoneList.Check(ctx, p)
+	if err != nil {
+		return "", nil, err
+	}
+	return p, listed, nil
 }
 
 // VerifyOTP godoc
 // @Summary      Verify OTP (login/register)
-// @Description  Validates OTP; creates user if not exists; returns JWT.
+// @Description  Validates OTP; creates user if not exists; returns a short-lived JWT plus a refresh token. Too many wrong guesses invalidate the code.
+// @Description  If the user has TOTP enabled, returns a short-lived mfa_token instead, to be upgraded via /auth/verify-totp.
+// @Description  With a purpose other than login (the code must have been requested for it) the caller must send its access token as Bearer and nobody is logged in: the answer is a StepUpResp whose step_up_token proves this user passed that OTP on the phone; change_phone tokens are redeemed at PUT /me/phone, delete_account tokens at DELETE /me, each only once.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        payload body VerifyOTPReq true "Verify payload"
-// @Success      200 {object} AuthResp
+// @Success      200 {object} VerifyOTPResp
+// @Success      202 {object} MFAResp
 // @Failure      400 {object} map[string]string
+// @Failure      401 {object} map[string]string
+// @Failure      403 {object} map[string]string
+// @Failure      429 {object} map[string]string
+// @Failure      503 {object} map[string]string
 // @Router       /auth/verify-otp [post]
 func (h *AuthHandler) VerifyOTP(c *fiber.Ctx) error {
 	var req VerifyOTPReq
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
 	}
-	if !phoneRx.MatchString(req.Phone) || len(req.OTP) != 6 {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid inputs"})
+	purpose, ok := otpPurpose(req.Purpose)
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "unknown purpose"})
+	}
+	phoneNum, listed, err := h.checkPhone(c.Context(), req.Phone)
+	if err != nil {
+		return phoneError(c, err)
+	}
+	req.OTP = h.OTPFormat.Canonical(strings.TrimSpace(req.OTP))
+	if !h.OTPFormat.Valid(req.OTP) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "otp must be " + h.OTPFormat.String()})
+	}
+	// step-ups are bound to a logged-in user: check before the code is used up
+	var caller *jwtutil.Principal
+	if purpose != otp.PurposeLogin {
+		if caller, err = h.caller(c); err != nil {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "revocation check failed"})
+		}
+		if caller == nil {
+			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "login required for purpose " + purpose})
+		}
 	}
 
-	ok, err := h.OTP.Validate(c.Context(), req.Phone, req.OTP)
+	ok, err = h.OTP.Validate(c.Context(), purpose, phoneNum, req.OTP)
+	if errors.Is(err, otp.ErrTooManyAttempts) {
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "too many attempts, request a new otp"})
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "otp validation error"})
 	}
 	if !ok {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid or expired otp"})
 	}
+	if purpose != otp.PurposeLogin {
+		return h.stepUp(c, caller.UserID, purpose, phoneNum)
+	}
+
+	ctx := context.Background()
+	u, err := h.Users.FindOrCreateByPhone(ctx, &user.User{
+		ID: uuid.NewString(), Phone: phoneNum, RegisteredAt: time.Now().UTC(), Role: user.RoleUser,
+		TestAccount: listed != nil && listed.Kind == phonelist.KindTest,
+	})
+	if err != nil {
+		return userError(c, err)
+	}
+	if u.Role != user.RoleAdmin && slices.Contains(h.AdminPhones, u.Phone) {
+		if err := h.Users.SetRole(ctx, u.ID, user.RoleAdmin); err != nil {
+			return userError(c, err)
+		}
+		u.Role = user.RoleAdmin
+	}
+
+	if u.TOTPEnabled {
+		mfa, err := h.Tokens.MFA(u.ID, h.MFATokenTTL)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "token error"})
+		}
+		return c.Status(http.StatusAccepted).JSON(MFAResp{MFARequired: true, MFAToken: mfa})
+	}
+
+	resp, err := h.issueTokens(ctx, u, "")
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "token error"})
+	}
+	return c.JSON(resp)
+}
+
+// caller returns the principal of a valid, unrevoked bearer access token, or nil when
+// the request has none (verify-otp is public, so middleware.Auth does not run).
+func (h *AuthHandler) caller(c *fiber.Ctx) (*jwtutil.Principal, error) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+		return nil, nil
+	}
+	p, err := h.Verifier.Verify(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return nil, nil
+	}
+	revoked, err := h.Revocations.IsRevoked(c.Context(), p.TokenID, p.UserID, p.IssuedAt)
+	if err != nil || revoked {
+		return nil, err
+	}
+	return p, nil
+}
+
+// stepUp answers verify-otp for a purpose other than login.
+func (h *AuthHandler) stepUp(c *fiber.Ctx, userID, purpose, phoneNum string) error {
+	tok, err := h.Tokens.StepUp(userID, phoneNum, purpose, h.StepUpTokenTTL)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "token error"})
+	}
+	return c.JSON(StepUpResp{
+		StepUpToken: tok,
+		ExpiresIn:   int64(h.StepUpTokenTTL.Seconds()),
+		Purpose:     purpose,
+		Phone:       phoneNum,
+	})
+}
+
+// VerifyTOTP godoc
+// @Summary      Verify TOTP (second factor)
+// @Description  Upgrades the mfa_token from verify-otp to a full JWT using the authenticator app code.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body VerifyTOTPReq true "TOTP payload"
+// @Success      200 {object} AuthResp
+// @Failure      400 {object} map[string]string
+// @Failure      401 {object} map[string]string
+// @Failure      429 {object} RateLimitResp
+// @Failure      503 {object} map[string]string
+// @Router       /auth/verify-totp [post]
+func (h *AuthHandler) VerifyTOTP(c *fiber.Ctx) error {
+	var req VerifyTOTPReq
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	mfa, err := h.Verifier.VerifyMFA(req.MFAToken)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid mfa token"})
+	}
+	// revoke-sessions also covers logins still waiting for their TOTP code
+	revoked, err := h.Revocations.IsRevoked(c.Context(), mfa.TokenID, mfa.UserID, mfa.IssuedAt)
+	if err != nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "revocation check failed"})
+	}
+	if revoked {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid mfa token"})
+	}
+	userID := mfa.UserID
+
+	res, err := h.Limiter.Allow(c.Context(), "totp:"+userID, h.TOTPPolicy)
+	if err != nil {
+		return otpError(c, "rate limit error", err)
+	}
+	if !res.Allowed {
+		return rateLimited(c, "too many attempts", scopeTOTP, res)
+	}
 
 	ctx := context.Background()
-	u, _ := h.Users.GetByPhone(ctx, req.Phone)
-	if u == nil {
-		u = &user.User{ID: uuid.NewString(), Phone: req.Phone, RegisteredAt: time.Now().UTC()}
-		if err := h.Users.Create(ctx, u); err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "create user failed"})
+	u, err := h.Users.GetByID(ctx, userID)
+	if err != nil && !errors.Is(err, user.ErrNotFound) {
+		return userError(c, err)
+	}
+	if u == nil || !u.TOTPEnabled {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid mfa token"})
+	}
+	ok, err := verifyTOTP(ctx, h.Users, h.TOTPCipher, u, req.Code)
+	if err != nil {
+		log.Printf("totp: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "totp error"})
+	}
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid totp code"})
+	}
+
+	resp, err := h.issueTokens(ctx, u, "")
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "token error"})
+	}
+	return c.JSON(resp)
+}
+
+// Refresh godoc
+// @Summary      Refresh tokens
+// @Description  Rotates a refresh token: returns a new access + refresh token pair and invalidates the old one.
+// @Description  Presenting an already used refresh token revokes every token of that login (token family).
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body RefreshReq true "Refresh payload"
+// @Success      200 {object} AuthResp
+// @Failure      400 {object} map[string]string
+// @Failure      401 {object} map[string]string
+// @Failure      503 {object} map[string]string
+// @Router       /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	var req RefreshReq
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+
+	ctx := context.Background()
+	rt, err := h.RefreshTokens.GetByHash(ctx, token.Hash(req.RefreshToken))
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "refresh error"})
+	}
+	if rt == nil || rt.RevokedAt != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid refresh token"})
+	}
+	now := time.Now().UTC()
+	if now.After(rt.ExpiresAt) {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "refresh token expired"})
+	}
+	// already used (or lost a concurrent rotation): assume theft, kill the family
+	won := false
+	if rt.UsedAt == nil {
+		if won, err = h.RefreshTokens.MarkUsed(ctx, rt.ID, now); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "refresh error"})
+		}
+	}
+	if !won {
+		if err := h.RefreshTokens.RevokeFamily(ctx, rt.FamilyID, now); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "refresh error"})
 		}
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "refresh token reuse detected"})
 	}
 
-	tok, err := jwtutil.Generate(h.JWTSecret, u.ID, h.TokenTTL)
+	u, err := h.Users.GetByID(ctx, rt.UserID)
+	if errors.Is(err, user.ErrNotFound) {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid refresh token"})
+	}
+	if err != nil {
+		return userError(c, err)
+	}
+	resp, err := h.issueTokens(ctx, u, rt.FamilyID)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "token error"})
 	}
-	return c.JSON(AuthResp{Token: tok, User: *u})
+	return c.JSON(resp)
+}
+
+// Logout godoc
+// @Summary      Logout
+// @Description  Revokes the presented access token. If a refresh_token is sent too, its whole token family is revoked.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        payload body RefreshReq false "Optional refresh token to revoke"
+// @Success      200 {object} map[string]string
+// @Failure      401 {object} map[string]string
+// @Security     Bearer
+// @Router       /auth/logout [post]
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	p := middleware.Principal(c)
+	ctx := context.Background()
+	if err := h.Revocations.RevokeToken(ctx, p.TokenID, p.ExpiresAt); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "logout failed"})
+	}
+
+	var req RefreshReq
+	_ = c.BodyParser(&req) // body is optional
+	if req.RefreshToken != "" {
+		rt, err := h.RefreshTokens.GetByHash(ctx, token.Hash(req.RefreshToken))
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "logout failed"})
+		}
+		if rt != nil && rt.UserID == p.UserID {
+			if err := h.RefreshTokens.RevokeFamily(ctx, rt.FamilyID, time.Now().UTC()); err != nil {
+				return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "logout failed"})
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"message": "logged out"})
+}
+
+// issueTokens mints an access token and a refresh token in familyID (new family if empty).
+func (h *AuthHandler) issueTokens(ctx context.Context, u *user.User, familyID string) (AuthResp, error) {
+	access, err := h.Tokens.Access(u.ID, u.Role, h.TokenTTL)
+	if err != nil {
+		return AuthResp{}, err
+	}
+	raw, hash, err := token.NewOpaque()
+	if err != nil {
+		return AuthResp{}, err
+	}
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	now := time.Now().UTC()
+	rt := &token.RefreshToken{
+		ID:        uuid.NewString(),
+		FamilyID:  familyID,
+		UserID:    u.ID,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.RefreshTTL),
+	}
+	if err := h.RefreshTokens.Create(ctx, rt); err != nil {
+		return AuthResp{}, err
+	}
+	return AuthResp{Token: access, ExpiresIn: int64(h.TokenTTL.Seconds()), RefreshToken: raw, User: *u}, nil
 }
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	memoryotp "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	memphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/memory"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+	"github.com/TheAmirMohammad/otp-service/internal/totp"
+)
+
+// newAuthHandler wires an AuthHandler on in-memory stores, with no rate limits or
+// challenges, and an app serving its public routes.
+func newAuthHandler(t *testing.T) (*AuthHandler, *fiber.App) {
+	t.Helper()
+	ctx := t.Context()
+	phones, err := phone.NewNormalizer("IR", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := phonelist.New(memphonelist.NewStore(), nil, otp.Format{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := jwtutil.NewHMACKeySet("secret")
+	cipher, err := totp.NewCipher("totp-key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthHandler{
+		OTP: memoryotp.NewManager(ctx, otp.Options{
+			TTL:         time.Minute,
+			MaxAttempts: 3,
+			Hasher:      otp.NewHasher("otp-secret", nil),
+			Sender:      nopSender{},
+			TestNumbers: list,
+		}, memoryotp.JanitorOptions{}),
+		Limiter:        memoryotp.NewLimiter(ctx, memoryotp.JanitorOptions{}),
+		Tokens:         &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"},
+		Verifier:       jwtutil.NewVerifier(keys, "iss", "aud", 0),
+		TokenTTL:       time.Minute,
+		Users:          memory.NewUserRepo(),
+		Phones:         phones,
+		PhoneList:      list,
+		RefreshTokens:  memory.NewRefreshRepo(ctx, 0),
+		RefreshTTL:     time.Hour,
+		Revocations:    memrevocation.NewStore(time.Minute),
+		MFATokenTTL:    time.Minute,
+		StepUpTokenTTL: time.Minute,
+		TOTPCipher:     cipher,
+	}
+	app := fiber.New()
+	app.Post("/request-otp", h.RequestOTP)
+	app.Post("/verify-otp", h.VerifyOTP)
+	app.Post("/verify-totp", h.VerifyTOTP)
+	app.Post("/refresh", h.Refresh)
+	return h, app
+}
+
+type nopSender struct{}
+
+func (nopSender) Send(context.Context, string, string, string, time.Duration) error { return nil }
+
+// postJSON sends body to path and returns the status and the decoded JSON object.
+func postJSON(t *testing.T, app *fiber.App, path string, body any) (int, map[string]any) {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, strings.NewReader(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	var out map[string]any
+	_ = json.Unmarshal(raw, &out)
+	return resp.StatusCode, out
+}
+
+func TestRefreshRotation(t *testing.T) {
+	h, app := newAuthHandler(t)
+	ctx := context.Background()
+	u := &user.User{ID: "alice", Phone: "+989121111111", Role: user.RoleUser}
+	if err := h.Users.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	login := func() string {
+		resp, err := h.issueTokens(ctx, u, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.RefreshToken
+	}
+	refresh := func(tok string) (int, string) {
+		t.Helper()
+		status, body := postJSON(t, app, "/refresh", RefreshReq{RefreshToken: tok})
+		next, _ := body["refresh_token"].(string)
+		return status, next
+	}
+
+	first := login()
+	other := login() // a second device: its own family
+
+	status, second := refresh(first)
+	if status != http.StatusOK || second == "" || second == first {
+		t.Fatalf("refresh = %d, %q", status, second)
+	}
+	status, third := refresh(second)
+	if status != http.StatusOK || third == "" {
+		t.Fatalf("second refresh = %d", status)
+	}
+
+	// reusing a rotated token revokes the whole family, including its newest token
+	if status, _ := refresh(first); status != http.StatusUnauthorized {
+		t.Fatalf("reuse = %d, want 401", status)
+	}
+	if status, _ := refresh(third); status != http.StatusUnauthorized {
+		t.Fatalf("newest token of the revoked family = %d, want 401", status)
+	}
+	// the other login is not affected
+	if status, _ := refresh(other); status != http.StatusOK {
+		t.Fatalf("other family = %d, want 200", status)
+	}
+
+	if status, _ := refresh("not-a-token"); status != http.StatusUnauthorized {
+		t.Fatalf("unknown token = %d, want 401", status)
+	}
+	h.RefreshTTL = -time.Second
+	if status, _ := refresh(login()); status != http.StatusUnauthorized {
+		t.Fatalf("expired token = %d, want 401", status)
+	}
+}
+
+func TestRefreshRevokedForUser(t *testing.T) {
+	h, app := newAuthHandler(t)
+	ctx := context.Background()
+	u := &user.User{ID: "alice", Phone: "+989121111111", Role: user.RoleUser}
+	if err := h.Users.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.issueTokens(ctx, u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, u.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := postJSON(t, app, "/refresh", RefreshReq{RefreshToken: resp.RefreshToken}); status != http.StatusUnauthorized {
+		t.Fatalf("refresh after revoke-sessions = %d, want 401", status)
+	}
+}
+
+func TestVerifyTOTPAfterRevokeSessions(t *testing.T) {
+	h, app := newAuthHandler(t)
+	ctx := context.Background()
+	secret, _ := totp.GenerateSecret()
+	sealed, _ := h.TOTPCipher.Seal("alice", secret)
+	u := &user.User{ID: "alice", Phone: "+989121111111", Role: user.RoleUser, TOTPEnabled: true, TOTPSecret: sealed}
+	if err := h.Users.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	// mfa signs a partial token as if the OTP step had passed at iat
+	mfa := func(iat time.Time) string {
+		tok, err := h.Tokens.Keys.Sign(jwtutil.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        iat.String(),
+				Subject:   u.ID,
+				Issuer:    "iss",
+				Audience:  jwt.ClaimStrings{"aud"},
+				IssuedAt:  jwt.NewNumericDate(iat),
+				ExpiresAt: jwt.NewNumericDate(iat.Add(time.Minute)),
+			},
+			Type: jwtutil.TypeMFA,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	verify := func(tok string, at time.Time) int {
+		t.Helper()
+		code, _ := totp.Code(secret, at)
+		status, _ := postJSON(t, app, "/verify-totp", VerifyTOTPReq{MFAToken: tok, Code: code})
+		return status
+	}
+
+	now := time.Now()
+	pending := mfa(now.Add(-2 * time.Second))
+	if err := h.Revocations.RevokeUser(ctx, u.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if status := verify(pending, now); status != http.StatusUnauthorized {
+		t.Fatalf("mfa token issued before revoke-sessions = %d, want 401", status)
+	}
+	// iat has second precision, so a token from the revocation second is caught too
+	if status := verify(mfa(now), now.Add(30*time.Second)); status != http.StatusUnauthorized {
+		t.Fatalf("mfa token issued in the revocation second = %d, want 401", status)
+	}
+	time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+	if status := verify(mfa(time.Now()), now.Add(30*time.Second)); status != http.StatusOK {
+		t.Fatalf("mfa token issued after the revocation second = %d, want 200", status)
+	}
+}
+
+func TestVerifyOTPConcurrentSingleLogin(t *testing.T) {
+	h, app := newAuthHandler(t)
+	const phoneNum = "+989121111111"
+	iss, err := h.OTP.Generate(context.Background(), otp.PurposeLogin, phoneNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		tokens   []string
+	)
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			status, body := postJSON(t, app, "/verify-otp", VerifyOTPReq{Phone: phoneNum, OTP: iss.Code})
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[status]++
+			if tok, ok := body["refresh_token"].(string); ok {
+				tokens = append(tokens, tok)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if statuses[http.StatusOK] != 1 || statuses[http.StatusBadRequest] != n-1 || len(tokens) != 1 {
+		t.Fatalf("statuses = %v, %d token pairs; want exactly one login", statuses, len(tokens))
+	}
+	if _, total, err := h.Users.List(context.Background(), user.ListFilter{}); err != nil || total != 1 {
+		t.Fatalf("%d users, %v; want 1", total, err)
+	}
+}
+
+func TestVerifyOTPStepUpRequiresLogin(t *testing.T) {
+	h, app := newAuthHandler(t)
+	ctx := context.Background()
+	const phoneNum = "+989123333333"
+	iss, err := h.OTP.Generate(ctx, otp.PurposeChangePhone, phoneNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := VerifyOTPReq{Phone: phoneNum, OTP: iss.Code, Purpose: otp.PurposeChangePhone}
+	verify := func(bearer string) (int, map[string]any) {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/verify-otp", strings.NewReader(string(b)))
+		req.Header.Set("Content-Type", "application/json")
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if status, _ := verify(""); status != http.StatusUnauthorized {
+		t.Fatalf("step-up without login = %d, want 401", status)
+	}
+	if status, _ := verify("garbage"); status != http.StatusUnauthorized {
+		t.Fatalf("step-up with an invalid token = %d, want 401", status)
+	}
+	// the refused attempts did not use up the code
+	access, _ := h.Tokens.Access("alice", user.RoleUser, time.Minute)
+	status, out := verify(access)
+	if status != http.StatusOK {
+		t.Fatalf("step-up = %d, %v", status, out)
+	}
+	proof, err := h.Verifier.VerifyStepUp(out["step_up_token"].(string), otp.PurposeChangePhone)
+	if err != nil || proof.UserID != "alice" || proof.Phone != phoneNum {
+		t.Fatalf("step-up token = %+v, %v", proof, err)
+	}
+}
+
+func TestVerifyOTPMarksTestAccounts(t *testing.T) {
+	h, app := newAuthHandler(t)
+	ctx := context.Background()
+	const testPhone, realPhone = "+989990000001", "+989121111111"
+	if _, err := h.PhoneList.Put(ctx, phonelist.Entry{Pattern: testPhone, Kind: phonelist.KindTest, Code: "424242"}); err != nil {
+		t.Fatal(err)
+	}
+	login := func(phoneNum, code string) {
+		t.Helper()
+		if status, body := postJSON(t, app, "/verify-otp", VerifyOTPReq{Phone: phoneNum, OTP: code}); status != http.StatusOK {
+			t.Fatalf("login %s = %d %v, want 200", phoneNum, status, body)
+		}
+	}
+
+	if status, body := postJSON(t, app, "/request-otp", RequestOTPReq{Phone: testPhone}); status != http.StatusOK {
+		t.Fatalf("request-otp for a test number = %d %v", status, body)
+	}
+	login(testPhone, "424242")
+	iss, err := h.OTP.Generate(ctx, otp.PurposeLogin, realPhone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login(realPhone, iss.Code)
+
+	for phoneNum, want := range map[string]bool{testPhone: true, realPhone: false} {
+		u, err := h.Users.GetByPhone(ctx, phoneNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.TestAccount != want {
+			t.Errorf("%s: TestAccount = %v, want %v", phoneNum, u.TestAccount, want)
+		}
+	}
+}
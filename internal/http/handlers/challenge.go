@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// ChallengeResp is the 428 body of request-otp once a soft threshold is crossed:
+// solve the challenge and retry with the solution in "challenge".
+type ChallengeResp struct {
+	Error     string              `json:"error"`
+	Scope     string              `json:"scope"` // layer past its soft threshold: phone | ip
+	Challenge challenge.Challenge `json:"challenge"`
+}
+
+// needsChallenge returns the first layer (phone, then client subnet) whose soft
+// threshold is reached within its window, or "" when none is or challenges are off.
+func (h *AuthHandler) needsChallenge(ctx context.Context, phone, subnet string) (string, error) {
+	if h.Challenge == nil {
+		return "", nil
+	}
+	layers := []struct {
+		scope, key string
+		soft       int
+		policy     otp.Policy
+	}{
+		{scopePhone, phoneLimitKey(phone), h.Limits.PhoneSoft, h.Limits.Phone},
+		{scopeIP, ipLimitKey(subnet), h.Limits.IPSoft, h.Limits.IP},
+	}
+	for _, l := range layers {
+		if l.soft <= 0 {
+			continue
+		}
+		res, err := h.Limiter.Peek(ctx, l.key, otp.Policy{Limit: l.soft, Window: l.policy.Window})
+		if err != nil {
+			return "", err
+		}
+		if !res.Allowed {
+			return l.scope, nil
+		}
+	}
+	return "", nil
+}
+
+// challengeRequired answers 428 with a freshly issued challenge. Issuing is limited
+// per client subnet (Limits.Challenges), so neither fresh requests nor wrong answers
+// can mint puzzles without bound; past it the client gets 429 instead.
+func (h *AuthHandler) challengeRequired(c *fiber.Ctx, msg, scope, subnet string) error {
+	res, err := h.Limiter.Allow(c.Context(), challengeLimitKey(subnet), h.Limits.Challenges)
+	if err != nil {
+		return otpError(c, "rate limit error", err)
+	}
+	if !res.Allowed {
+		return rateLimited(c, "too many challenges", scopeChallenge, res)
+	}
+	ch, err := h.Challenge.Issue(c.Context())
+	if errors.Is(err, challenge.ErrStoreFull) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(60))
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "server busy, retry later"})
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "challenge error"})
+	}
+	return c.Status(http.StatusPreconditionRequired).JSON(ChallengeResp{Error: msg, Scope: scope, Challenge: ch})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/challenge"
+	memorychallenge "github.com/TheAmirMohammad/otp-service/internal/challenge/memory"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	memoryotp "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
+)
+
+func TestChallengeIssueLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	issue := func(t *testing.T, maxPuzzles int, policy otp.Policy) func(subnet string) int {
+		store := memorychallenge.NewStore(ctx, maxPuzzles, 0)
+		t.Cleanup(func() { _ = store.Close() })
+		h := &AuthHandler{
+			Limiter:   memoryotp.NewLimiter(ctx, memoryotp.JanitorOptions{}),
+			Limits:    OTPLimits{Challenges: policy},
+			Challenge: challenge.NewPoW(store, 4, time.Minute),
+		}
+		app := fiber.New()
+		app.Get("/:subnet", func(c *fiber.Ctx) error {
+			return h.challengeRequired(c, "challenge required", scopeIP, c.Params("subnet"))
+		})
+		return func(subnet string) int {
+			t.Helper()
+			resp, err := app.Test(httptest.NewRequest("GET", "/"+subnet, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode
+		}
+	}
+
+	t.Run("per subnet", func(t *testing.T) {
+		get := issue(t, 0, otp.Policy{Limit: 2, Window: time.Minute})
+		for i, want := range []int{http.StatusPreconditionRequired, http.StatusPreconditionRequired, http.StatusTooManyRequests} {
+			if got := get("a"); got != want {
+				t.Fatalf("issue %d = %d, want %d", i+1, got, want)
+			}
+		}
+		if got := get("b"); got != http.StatusPreconditionRequired {
+			t.Fatalf("other subnet = %d, want 428", got)
+		}
+	})
+
+	t.Run("store full", func(t *testing.T) {
+		get := issue(t, 1, otp.Policy{})
+		if got := get("a"); got != http.StatusPreconditionRequired {
+			t.Fatalf("first = %d, want 428", got)
+		}
+		if got := get("a"); got != http.StatusServiceUnavailable {
+			t.Fatalf("at the cap = %d, want 503", got)
+		}
+	})
+}
+
+// countingProvider accepts every solution and counts the ones it was asked to check.
+type countingProvider struct{ verified int }
+
+func (p *countingProvider) Issue(context.Context) (challenge.Challenge, error) {
+	return challenge.Challenge{Kind: challenge.KindCaptcha, SiteKey: "site"}, nil
+}
+
+func (p *countingProvider) Verify(context.Context, challenge.Solution, string) (bool, error) {
+	p.verified++
+	return true, nil
+}
+
+func TestRequestOTPHardLimitBeforeChallenge(t *testing.T) {
+	h, app := newAuthHandler(t)
+	provider := &countingProvider{}
+	h.Challenge = provider
+	h.Limits = OTPLimits{Phone: otp.Policy{Limit: 2, Window: time.Minute}, PhoneSoft: 1, IPv4Prefix: 32, IPv6Prefix: 64}
+	const phoneNum = "+989121111111"
+	spend := func() {
+		t.Helper()
+		if _, err := h.Limiter.Allow(context.Background(), phoneLimitKey(phoneNum), h.Limits.Phone); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// past the soft threshold only: a challenge is asked for
+	spend()
+	if status, body := postJSON(t, app, "/request-otp", RequestOTPReq{Phone: phoneNum}); status != http.StatusPreconditionRequired {
+		t.Fatalf("past the soft threshold = %d %v, want 428", status, body)
+	}
+
+	// past the hard limit: 429 straight away, the solution is neither demanded nor spent
+	spend()
+	for _, sol := range []*challenge.Solution{nil, {Token: "solved"}} {
+		status, body := postJSON(t, app, "/request-otp", RequestOTPReq{Phone: phoneNum, Challenge: sol})
+		if status != http.StatusTooManyRequests || body["scope"] != scopePhone {
+			t.Fatalf("past the hard limit = %d %v, want 429 scope phone", status, body)
+		}
+	}
+	if provider.verified != 0 {
+		t.Fatalf("challenge verified %d times for a refused request", provider.verified)
+	}
+}
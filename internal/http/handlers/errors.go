@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+)
+
+// userError maps a user.Repository error to a response:
+// 404 not found, 409 conflict, 503 when the store itself failed.
+func userError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not found"})
+	case errors.Is(err, user.ErrConflict):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "user already exists"})
+	default:
+		log.Printf("user repo: %v", err)
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "storage unavailable"})
+	}
+}
+
+// phoneError maps a phone.Normalizer or phonelist error: 400 invalid, 403 for a
+// blocked country or number, 503 when the phone list store failed.
+func phoneError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, phone.ErrInvalid):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid phone"})
+	case errors.Is(err, phone.ErrCountryBlocked):
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "phone country not allowed"})
+	case errors.Is(err, phonelist.ErrBlocked):
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "phone blocked"})
+	default:
+		log.Printf("phone list: %v", err)
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "storage unavailable"})
+	}
+}
+
+// otpError maps an otp.Service or otp.Limiter failure: 503 with Retry-After when the
+// in-memory stores are full (they refuse new keys rather than evict live state), 500 otherwise.
+func otpError(c *fiber.Ctx, msg string, err error) error {
+	if errors.Is(err, otp.ErrCapacity) {
+		c.Set(fiber.HeaderRetryAfter, "60")
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "server busy, retry later"})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": msg})
+}
+
+// phoneListError maps a phonelist.List edit error: 400 invalid entry, 409 config entry, 503 store failure.
+func phoneListError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, phonelist.ErrInvalid):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, phonelist.ErrReadOnly):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "entry comes from config, edit it there"})
+	default:
+		log.Printf("phone list: %v", err)
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "storage unavailable"})
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+)
+
+func TestUserErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{user.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("get: %w", user.ErrNotFound), http.StatusNotFound},
+		{user.ErrConflict, http.StatusConflict},
+		{fmt.Errorf("users: %w", errors.New("connection refused")), http.StatusServiceUnavailable},
+	} {
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error { return userError(c, tc.err) })
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("userError(%v) = %d, want %d", tc.err, resp.StatusCode, tc.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// OTPLimits are the layered request-otp budgets. A zero Limit disables a layer.
+type OTPLimits struct {
+	Phone  otp.Policy // per normalized phone
+	IP     otp.Policy // per client subnet
+	Global otp.Policy // whole service, against SMS pumping
+
+	// Challenges bounds the puzzles issued per client subnet, wrong answers included
+	Challenges otp.Policy
+
+	// Soft thresholds: past this many requests in the Phone / IP window, request-otp
+	// asks for a challenge (proof-of-work or CAPTCHA) first. 0 disables.
+	PhoneSoft int
+	IPSoft    int
+
+	// Client IPs are grouped into subnets of these sizes (e.g. 32 and 64)
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+// Rate limit layers, as reported to clients
+const (
+	scopePhone     = "phone"
+	scopeIP        = "ip"
+	scopeGlobal    = "global"
+	scopeTOTP      = "totp"
+	scopeCooldown  = "cooldown"  // resend cooldown of the phone's last code
+	scopeChallenge = "challenge" // challenges issued to the client subnet
+)
+
+// RateLimitResp is the 429 body of rate limited endpoints (also in RateLimit-* headers).
+type RateLimitResp struct {
+	Error      string    `json:"error"`
+	Scope      string    `json:"scope"` // exhausted layer: phone | ip | global | totp | cooldown | challenge
+	Limit      int       `json:"limit"`
+	Remaining  int       `json:"remaining"`
+	RetryAfter int       `json:"retry_after"` // seconds
+	ResetAt    time.Time `json:"reset_at"`
+}
+
+// otpLayer is one hard rate limit layer of request-otp.
+type otpLayer struct {
+	scope, key string
+	policy     otp.Policy
+}
+
+// otpLayers lists the request-otp layers in order: phone, client subnet, global.
+func (h *AuthHandler) otpLayers(phone, subnet string) []otpLayer {
+	return []otpLayer{
+		{scopePhone, phoneLimitKey(phone), h.Limits.Phone},
+		{scopeIP, ipLimitKey(subnet), h.Limits.IP},
+		{scopeGlobal, "otp:global", h.Limits.Global},
+	}
+}
+
+// peekOTPRequest reports the first exhausted request-otp layer without counting
+// anything, or scope "" when every layer still has room.
+func (h *AuthHandler) peekOTPRequest(ctx context.Context, phone, subnet string) (string, otp.Result, error) {
+	for _, l := range h.otpLayers(phone, subnet) {
+		res, err := h.Limiter.Peek(ctx, l.key, l.policy)
+		if err != nil {
+			return "", otp.Result{}, err
+		}
+		if !res.Allowed {
+			return l.scope, res, nil
+		}
+	}
+	return "", otp.Result{}, nil
+}
+
+// allowOTPRequest checks the request-otp layers in order: phone, client subnet, global.
+// It returns the scope and result of the exhausted layer, or scope "" and the result
+// of the layer closest to its limit when the request may proceed.
+//
+// Every layer is peeked before any is counted, so a request refused by the IP or
+// global layer does not use up the phone's budget (or the other way round).
+func (h *AuthHandler) allowOTPRequest(ctx context.Context, phone, subnet string) (string, otp.Result, error) {
+	if scope, res, err := h.peekOTPRequest(ctx, phone, subnet); err != nil || scope != "" {
+		return scope, res, err
+	}
+	var tightest otp.Result
+	for _, l := range h.otpLayers(phone, subnet) {
+		// a concurrent request may still take the last slot between Peek and Allow
+		res, err := h.Limiter.Allow(ctx, l.key, l.policy)
+		if err != nil {
+			return "", otp.Result{}, err
+		}
+		if !res.Allowed {
+			return l.scope, res, nil
+		}
+		if res.Limit > 0 && (tightest.Limit == 0 || res.Remaining < tightest.Remaining) {
+			tightest = res
+		}
+	}
+	return "", tightest, nil
+}
+
+// Limiter keys of the request-otp layers (also used by the admin endpoints).
+func phoneLimitKey(phone string) string { return "otp:phone:" + phone }
+func ipLimitKey(subnet string) string   { return "otp:ip:" + subnet }
+
+// challengeLimitKey is the limiter key of the challenges issued to a subnet.
+func challengeLimitKey(subnet string) string { return "challenge:ip:" + subnet }
+
+// setRateLimitHeaders emits RateLimit-Limit/Remaining/Reset for res, and Retry-After
+// when it was denied. Unlimited results set nothing.
+func setRateLimitHeaders(c *fiber.Ctx, res otp.Result) {
+	if res.Limit <= 0 {
+		return
+	}
+	reset := secondsUntil(res.ResetAt)
+	c.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(reset))
+	if !res.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(reset, 1)))
+	}
+}
+
+// resendCooldown answers 429 while the phone's resend cooldown runs.
+func resendCooldown(c *fiber.Ctx, wait time.Duration) error {
+	retry := max(ceilSeconds(wait), 1)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+	return c.Status(http.StatusTooManyRequests).JSON(RateLimitResp{
+		Error:      "resend cooldown",
+		Scope:      scopeCooldown,
+		RetryAfter: retry,
+		ResetAt:    time.Now().Add(wait).UTC(),
+	})
+}
+
+// rateLimited answers 429 with the headers and a RateLimitResp body.
+func rateLimited(c *fiber.Ctx, msg, scope string, res otp.Result) error {
+	setRateLimitHeaders(c, res)
+	return c.Status(http.StatusTooManyRequests).JSON(RateLimitResp{
+		Error:      msg,
+		Scope:      scope,
+		Limit:      res.Limit,
+		Remaining:  res.Remaining,
+		RetryAfter: max(secondsUntil(res.ResetAt), 1),
+		ResetAt:    res.ResetAt.UTC(),
+	})
+}
+
+// secondsUntil rounds the time left until t up to whole seconds (0 if past).
+func secondsUntil(t time.Time) int {
+	return ceilSeconds(time.Until(t))
+}
+
+// ceilSeconds rounds d up to whole seconds (0 if negative).
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 0)
+}
+
+// clientAddr is the client address: c.IP() (ProxyHeader when configured, validated by
+// Fiber), or the socket peer when that is not a valid IP.
+func clientAddr(c *fiber.Ctx) netip.Addr {
+	if addr, err := netip.ParseAddr(c.IP()); err == nil {
+		return addr
+	}
+	addr, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	return addr
+}
+
+// clientSubnet is the subnet of the client, the key of its rate limits.
+func (l OTPLimits) clientSubnet(c *fiber.Ctx) string {
+	return l.subnet(clientAddr(c))
+}
+
+// subnet masks addr to its v4/v6 prefix.
+func (l OTPLimits) subnet(addr netip.Addr) string {
+	addr = addr.Unmap()
+	bits := l.IPv6Prefix
+	if addr.Is4() {
+		bits = l.IPv4Prefix
+	}
+	if p, err := addr.Prefix(bits); err == nil {
+		return p.String()
+	}
+	return addr.String()
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	memoryotp "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
+)
+
+func TestClientSubnet(t *testing.T) {
+	limits := OTPLimits{IPv4Prefix: 32, IPv6Prefix: 64}
+	subnetOf := func(t *testing.T, cfg fiber.Config, forwarded string) string {
+		t.Helper()
+		app := fiber.New(cfg)
+		app.Get("/", func(c *fiber.Ctx) error { return c.SendString(limits.clientSubnet(c)) })
+		req := httptest.NewRequest("GET", "/", nil)
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	// app.Test connects from 0.0.0.0
+	trusted := fiber.Config{
+		ProxyHeader:             "X-Forwarded-For",
+		EnableIPValidation:      true,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          []string{"0.0.0.0"},
+	}
+	untrusted := trusted
+	untrusted.TrustedProxies = []string{"10.0.0.1"}
+	unvalidated := trusted
+	unvalidated.EnableIPValidation = false
+
+	for _, tc := range []struct {
+		name      string
+		cfg       fiber.Config
+		forwarded string
+		want      string
+	}{
+		{"no header", trusted, "", "0.0.0.0/32"},
+		{"trusted proxy", trusted, "203.0.113.7", "203.0.113.7/32"},
+		{"ipv6 grouped", trusted, "2001:db8::1", "2001:db8::/64"},
+		{"garbage header", trusted, "not-an-ip", "0.0.0.0/32"},
+		{"untrusted peer", untrusted, "203.0.113.7", "0.0.0.0/32"},
+		// without validation c.IP() is the raw header; it must never become a key
+		{"raw header", unvalidated, "random-123", "0.0.0.0/32"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := subnetOf(t, tc.cfg, tc.forwarded); got != tc.want {
+				t.Errorf("subnet = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAllowOTPRequestLayers(t *testing.T) {
+	ctx := context.Background()
+	h := &AuthHandler{
+		Limiter: memoryotp.NewLimiter(t.Context(), memoryotp.JanitorOptions{}),
+		Limits: OTPLimits{
+			Phone:  otp.Policy{Limit: 2, Window: time.Minute},
+			IP:     otp.Policy{Limit: 3, Window: time.Minute},
+			Global: otp.Policy{Limit: 5, Window: time.Minute},
+		},
+	}
+	request := func(phone, subnet, wantScope string, wantRemaining int) {
+		t.Helper()
+		scope, res, err := h.allowOTPRequest(ctx, phone, subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope != wantScope || res.Remaining != wantRemaining {
+			t.Fatalf("request(%s, %s) = %q, %d left; want %q, %d left", phone, subnet, scope, res.Remaining, wantScope, wantRemaining)
+		}
+	}
+	used := func(key string, p otp.Policy) int {
+		t.Helper()
+		res, err := h.Limiter.Peek(ctx, key, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Limit - res.Remaining
+	}
+	const a, b = "10.0.0.1/32", "10.0.0.2/32"
+
+	request("+989120000001", a, "", 1)         // phone 1/2, ip 1/3, global 1/5
+	request("+989120000001", a, "", 0)         // phone 2/2 is the tightest layer
+	request("+989120000001", a, scopePhone, 0) // refused by the phone layer only
+	if n := used(ipLimitKey(a), h.Limits.IP); n != 2 {
+		t.Fatalf("a phone-limited request used the ip budget: %d/3", n)
+	}
+	if n := used("otp:global", h.Limits.Global); n != 2 {
+		t.Fatalf("a phone-limited request used the global budget: %d/5", n)
+	}
+
+	request("+989120000002", a, "", 0)      // ip 3/3
+	request("+989120000003", a, scopeIP, 0) // refused by the ip layer only
+	if n := used(phoneLimitKey("+989120000003"), h.Limits.Phone); n != 0 {
+		t.Fatalf("an ip-limited request used the phone budget: %d/2", n)
+	}
+
+	request("+989120000004", b, "", 1) // phone 1/2 and global 4/5: one left in each
+	request("+989120000005", b, "", 0) // global 5/5
+	request("+989120000006", b, scopeGlobal, 0)
+	if n := used(phoneLimitKey("+989120000006"), h.Limits.Phone); n != 0 {
+		t.Fatalf("a globally limited request used the phone budget: %d/2", n)
+	}
+	if n := used(ipLimitKey(b), h.Limits.IP); n != 2 {
+		t.Fatalf("a globally limited request used the ip budget: %d/3", n)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/totp"
+)
+
+// TOTPHandler manages authenticator-app enrollment for the calling user.
+type TOTPHandler struct {
+	Users   user.Repository
+	Limiter otp.Limiter
+	Policy  otp.Policy // caps TOTP guesses per user
+	Issuer  string     // shown in the authenticator app
+	Cipher  *totp.Cipher
+}
+
+type TOTPEnrollResp struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TOTPCodeReq struct {
+	Code string `json:"code"`
+}
+
+// Enroll godoc
+// @Summary   Start TOTP enrollment
+// @Description Generates a new secret (pending until confirmed) and its otpauth:// URI.
+// @Tags      totp
+// @Produce   json
+// @Success   200 {object} TOTPEnrollResp
+// @Failure   409 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /auth/totp/enroll [post]
+func (h *TOTPHandler) Enroll(c *fiber.Ctx) error {
+	u, err := h.currentUser(c)
+	if err != nil {
+		return userError(c, err)
+	}
+	if u.TOTPEnabled {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "totp already enabled"})
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "totp error"})
+	}
+	sealed, err := h.Cipher.Seal(u.ID, secret)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "totp error"})
+	}
+	if err := h.Users.SetTOTP(context.Background(), u.ID, sealed, false); err != nil {
+		return userError(c, err)
+	}
+	return c.JSON(TOTPEnrollResp{Secret: secret, URI: totp.URI(h.Issuer, u.Phone, secret)})
+}
+
+// Confirm godoc
+// @Summary   Confirm TOTP enrollment
+// @Description Enables TOTP once the user proves the app generates valid codes.
+// @Tags      totp
+// @Accept    json
+// @Produce   json
+// @Param     payload body TOTPCodeReq true "Code from the authenticator app"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   409 {object} map[string]string
+// @Failure   429 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /auth/totp/confirm [post]
+func (h *TOTPHandler) Confirm(c *fiber.Ctx) error {
+	u, err := h.currentUser(c)
+	if err != nil {
+		return userError(c, err)
+	}
+	if u.TOTPEnabled {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "totp already enabled"})
+	}
+	if u.TOTPSecret == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "no pending enrollment"})
+	}
+	if status, msg := h.checkCode(c, u); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := h.Users.SetTOTP(context.Background(), u.ID, u.TOTPSecret, true); err != nil {
+		return userError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "totp enabled"})
+}
+
+// Disable godoc
+// @Summary   Disable TOTP
+// @Description Removes the authenticator secret; requires a current code.
+// @Tags      totp
+// @Accept    json
+// @Produce   json
+// @Param     payload body TOTPCodeReq true "Code from the authenticator app"
+// @Success   200 {object} map[string]string
+// @Failure   400 {object} map[string]string
+// @Failure   429 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /auth/totp/disable [post]
+func (h *TOTPHandler) Disable(c *fiber.Ctx) error {
+	u, err := h.currentUser(c)
+	if err != nil {
+		return userError(c, err)
+	}
+	if !u.TOTPEnabled {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "totp not enabled"})
+	}
+	if status, msg := h.checkCode(c, u); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if err := h.Users.SetTOTP(context.Background(), u.ID, "", false); err != nil {
+		return userError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "totp disabled"})
+}
+
+// currentUser loads the caller set by the auth middleware.
+func (h *TOTPHandler) currentUser(c *fiber.Ctx) (*user.User, error) {
+	return h.Users.GetByID(context.Background(), middleware.Principal(c).UserID)
+}
+
+// checkCode validates the body code against u's secret (rate limited per user).
+// It returns a non-zero status and message when the request must be rejected.
+func (h *TOTPHandler) checkCode(c *fiber.Ctx, u *user.User) (int, string) {
+	var req TOTPCodeReq
+	if err := c.BodyParser(&req); err != nil {
+		return http.StatusBadRequest, "invalid body"
+	}
+	res, err := h.Limiter.Allow(c.Context(), "totp:"+u.ID, h.Policy)
+	if errors.Is(err, otp.ErrCapacity) {
+		return http.StatusServiceUnavailable, "server busy, retry later"
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "rate limit error"
+	}
+	if !res.Allowed {
+		setRateLimitHeaders(c, res)
+		return http.StatusTooManyRequests, "too many attempts"
+	}
+	ok, err := verifyTOTP(c.Context(), h.Users, h.Cipher, u, req.Code)
+	if err != nil {
+		log.Printf("totp: %v", err)
+		return http.StatusInternalServerError, "totp error"
+	}
+	if !ok {
+		return http.StatusBadRequest, "invalid totp code"
+	}
+	return 0, ""
+}
+
+// verifyTOTP checks code against u's secret and consumes its time step, so every
+// code is accepted once. A secret still in plaintext or sealed with a previous key
+// is sealed again with the current one.
+func verifyTOTP(ctx context.Context, users user.Repository, ciph *totp.Cipher, u *user.User, code string) (bool, error) {
+	secret, current, err := ciph.Open(u.ID, u.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Verify(secret, code, time.Now(), u.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	// compare-and-set: of two concurrent requests with the same code only one wins
+	if ok, err := users.AdvanceTOTPStep(ctx, u.ID, step); err != nil || !ok {
+		return false, err
+	}
+	u.TOTPLastStep = step
+	if !current {
+		sealed, err := ciph.Seal(u.ID, secret)
+		if err == nil {
+			err = users.SetTOTP(ctx, u.ID, sealed, u.TOTPEnabled)
+		}
+		if err != nil {
+			log.Printf("totp: re-seal secret of user %s: %v", u.ID, err)
+		} else {
+			u.TOTPSecret = sealed
+		}
+	}
+	return true, nil
+}
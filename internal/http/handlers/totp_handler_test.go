@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	memoryotp "github.com/TheAmirMohammad/otp-service/internal/otp/memory"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+	"github.com/TheAmirMohammad/otp-service/internal/totp"
+)
+
+func TestTOTPEnrollmentReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := jwtutil.NewHMACKeySet("secret")
+	signer := &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"}
+	users := memory.NewUserRepo()
+	cipher, _ := totp.NewCipher("totp-key", nil)
+	h := &TOTPHandler{Users: users, Limiter: memoryotp.NewLimiter(ctx, memoryotp.JanitorOptions{}), Issuer: "test", Cipher: cipher}
+	app := fiber.New()
+	g := app.Group("/totp", middleware.Auth(jwtutil.NewVerifier(keys, "iss", "aud", 0), memrevocation.NewStore(time.Hour), users))
+	g.Post("/enroll", h.Enroll)
+	g.Post("/confirm", h.Confirm)
+	g.Post("/disable", h.Disable)
+
+	u := user.User{ID: "alice", Phone: "+989121111111"}
+	if err := users.Create(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	access, _ := signer.Access(u.ID, user.RoleUser, time.Minute)
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+access)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post("/totp/enroll", "")
+	var enroll TOTPEnrollResp
+	if err := json.NewDecoder(resp.Body).Decode(&enroll); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("enroll = %d, %v", resp.StatusCode, err)
+	}
+	stored, _ := users.GetByID(ctx, u.ID)
+	if stored.TOTPSecret == enroll.Secret || strings.Contains(stored.TOTPSecret, enroll.Secret) {
+		t.Fatal("secret stored in plaintext")
+	}
+
+	code, _ := totp.Code(enroll.Secret, time.Now())
+	if resp := post("/totp/confirm", `{"code":"`+code+`"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirm = %d", resp.StatusCode)
+	}
+	// the same code cannot be used a second time
+	if resp := post("/totp/disable", `{"code":"`+code+`"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("disable with a used code = %d, want 400", resp.StatusCode)
+	}
+	if stored, _ := users.GetByID(ctx, u.ID); !stored.TOTPEnabled {
+		t.Fatal("totp disabled by a replayed code")
+	}
+}
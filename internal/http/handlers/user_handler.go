@@ -2,15 +2,63 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"net/mail"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
 	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phone"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
 )
 
-type UserHandler struct { Users user.Repository }
+type UserHandler struct {
+	Users     user.Repository
+	Phones    *phone.Normalizer // turns national-format searches into E.164 prefixes
+	PhoneList *phonelist.List   // nobody may move to a test number: its code is fixed
+
+	// Step-up tokens from verify-otp authorize changing the phone and deleting the account;
+	// redeemed tokens and ended sessions go to the revocation store
+	Verifier      *jwtutil.Verifier
+	RefreshTokens token.Repository
+	Revocations   revocation.Store
+}
+
+// StepUpReq carries the step_up_token verify-otp returned for the action's purpose.
+type StepUpReq struct {
+	StepUpToken string `json:"step_up_token"`
+}
+
+// ChangePhoneReq carries two change_phone step-up tokens: one for the new phone, and
+// one proving the caller still controls the current phone.
+type ChangePhoneReq struct {
+	StepUpToken        string `json:"step_up_token"`         // OTP sent to the new phone
+	CurrentStepUpToken string `json:"current_step_up_token"` // OTP sent to the current phone
+}
+
+// UpdateMeReq holds the profile fields to change; omitted fields are kept, "" clears one.
+type UpdateMeReq struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+	Locale      *string `json:"locale"`
+}
+
+// simple BCP 47 shape: language[-script][-region]...
+var localeRx = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+const maxDisplayName = 64
 
 type listResp struct {
 	Items []user.User `json:"items"`
@@ -21,28 +69,34 @@ type listResp struct {
 
 // GetUser godoc
 // @Summary   Get single user by ID
+// @Description Admins can read any user; other callers only themselves.
 // @Tags      users
 // @Produce   json
 // @Param     id path string true "User ID"
 // @Success   200 {object} user.User
+// @Failure   403 {object} map[string]string
 // @Failure   404 {object} map[string]string
+// @Failure   503 {object} map[string]string
 // @Security  Bearer
 // @Router    /users/{id} [get]
 func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 	id := c.Params("id")
-	u, _ := h.Users.GetByID(context.Background(), id)
-	if u == nil { return c.Status(http.StatusNotFound).JSON(fiber.Map{"error":"not found"}) }
+	u, err := h.Users.GetByID(context.Background(), id)
+	if err != nil { return userError(c, err) }
 	return c.JSON(u)
 }
 
 // ListUsers godoc
 // @Summary   List users with pagination & search
+// @Description Admin only.
 // @Tags      users
 // @Produce   json
 // @Param     page   query int    false "Page (1-based)" minimum(1) default(1)
 // @Param     size   query int    false "Page size" minimum(1) maximum(100) default(20)
-// @Param     search query string false "Search by phone"
+// @Param     search query string false "Search by phone (E.164 or national format, may be partial)"
 // @Success   200 {object} listResp
+// @Failure   403 {object} map[string]string
+// @Failure   503 {object} map[string]string
 // @Security  Bearer
 // @Router    /users [get]
 func (h *UserHandler) ListUsers(c *fiber.Ctx) error {
@@ -51,9 +105,185 @@ func (h *UserHandler) ListUsers(c *fiber.Ctx) error {
 	if page < 1 { page = 1 }
 	if size < 1 || size > 100 { size = 20 }
 	search := c.Query("search", "")
+	if h.Phones != nil { search = h.Phones.SearchTerm(search) }
 	items, total, err := h.Users.List(context.Background(), user.ListFilter{
 		Search: search, Limit: size, Offset: (page-1)*size,
 	})
-	if err != nil { return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error":"list error"}) }
+	if err != nil { return userError(c, err) }
 	return c.JSON(listResp{Items: items, Total: total, Page: page, Size: size})
-}
\ No newline at end of file
+}
+
+// GetMe godoc
+// @Summary   Get the calling user
+// @Tags      users
+// @Produce   json
+// @Success   200 {object} user.User
+// @Failure   401 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /me [get]
+func (h *UserHandler) GetMe(c *fiber.Ctx) error {
+	u, err := h.Users.GetByID(context.Background(), middleware.Principal(c).UserID)
+	if err != nil { return userError(c, err) }
+	return c.JSON(u)
+}
+
+// UpdateMe godoc
+// @Summary   Update the calling user's profile
+// @Description Only the fields present in the body are changed; an empty string clears a field.
+// @Tags      users
+// @Accept    json
+// @Produce   json
+// @Param     payload body UpdateMeReq true "Profile fields"
+// @Success   200 {object} user.User
+// @Failure   400 {object} map[string]string
+// @Failure   401 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /me [patch]
+func (h *UserHandler) UpdateMe(c *fiber.Ctx) error {
+	var req UpdateMeReq
+	if err := c.BodyParser(&req); err != nil { return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error":"invalid body"}) }
+	p, msg := req.normalize()
+	if msg != "" { return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error":msg}) }
+	u, err := h.Users.UpdateProfile(context.Background(), middleware.Principal(c).UserID, p)
+	if err != nil { return userError(c, err) }
+	return c.JSON(u)
+}
+
+// ChangePhone godoc
+// @Summary   Move the calling user to a new phone number
+// @Description First request-otp and verify-otp with purpose change_phone, while logged in, for both the new and the current phone. Both step-up tokens are used up.
+// @Description Every session of the user ends with the change, this one included: log in again with the new phone. Test numbers (PHONE_TEST_NUMBERS) cannot be moved to.
+// @Tags      users
+// @Accept    json
+// @Produce   json
+// @Param     payload body ChangePhoneReq true "Step-up tokens for change_phone"
+// @Success   200 {object} user.User
+// @Failure   400 {object} map[string]string
+// @Failure   401 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   409 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /me/phone [put]
+func (h *UserHandler) ChangePhone(c *fiber.Ctx) error {
+	var req ChangePhoneReq
+	if err := c.BodyParser(&req); err != nil || req.StepUpToken == "" || req.CurrentStepUpToken == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error":"step_up_token and current_step_up_token required"})
+	}
+	next, status, msg := h.checkStepUp(c, req.StepUpToken, otp.PurposeChangePhone)
+	if next == nil { return c.Status(status).JSON(fiber.Map{"error":msg}) }
+	current, status, msg := h.checkStepUp(c, req.CurrentStepUpToken, otp.PurposeChangePhone)
+	if current == nil { return c.Status(status).JSON(fiber.Map{"error":msg}) }
+	ctx := context.Background()
+	u, err := h.Users.GetByID(ctx, middleware.Principal(c).UserID)
+	if err != nil { return userError(c, err) }
+	if current.Phone != u.Phone { return c.Status(http.StatusForbidden).JSON(fiber.Map{"error":"current_step_up_token is not for your phone"}) }
+	if next.Phone == u.Phone { return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error":"step_up_token is for your current phone"}) }
+	if _, isTest, err := h.PhoneList.TestCode(ctx, next.Phone); err != nil {
+		return phoneListError(c, err)
+	} else if isTest {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error":"phone is a test number"})
+	}
+	if err := h.redeem(ctx, next, current); err != nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error":"revocation store unavailable"})
+	}
+	u, err = h.Users.SetPhone(ctx, u.ID, next.Phone)
+	if errors.Is(err, user.ErrConflict) { return c.Status(http.StatusConflict).JSON(fiber.Map{"error":"phone already in use"}) }
+	if err != nil { return userError(c, err) }
+	if err := h.endSessions(ctx, u.ID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error":"revoke failed"})
+	}
+	return c.JSON(u)
+}
+
+// DeleteMe godoc
+// @Summary   Delete the calling user's account
+// @Description First request-otp and verify-otp, while logged in, for the account's phone with purpose delete_account; the step_up_token must be for that phone. Refresh tokens are revoked and access tokens stop working.
+// @Tags      users
+// @Accept    json
+// @Produce   json
+// @Param     payload body StepUpReq true "Step-up token for delete_account"
+// @Success   204
+// @Failure   400 {object} map[string]string
+// @Failure   401 {object} map[string]string
+// @Failure   403 {object} map[string]string
+// @Failure   503 {object} map[string]string
+// @Security  Bearer
+// @Router    /me [delete]
+func (h *UserHandler) DeleteMe(c *fiber.Ctx) error {
+	tok, ok := stepUpToken(c)
+	if !ok { return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error":"step_up_token required"}) }
+	proof, status, msg := h.checkStepUp(c, tok, otp.PurposeDeleteAccount)
+	if proof == nil { return c.Status(status).JSON(fiber.Map{"error":msg}) }
+	ctx := context.Background()
+	u, err := h.Users.GetByID(ctx, middleware.Principal(c).UserID)
+	if err != nil { return userError(c, err) }
+	if u.Phone != proof.Phone { return c.Status(http.StatusForbidden).JSON(fiber.Map{"error":"step-up token is for another phone"}) }
+	if err := h.redeem(ctx, proof); err != nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error":"revocation store unavailable"})
+	}
+	if err := h.endSessions(ctx, u.ID); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error":"revoke failed"})
+	}
+	if err := h.Users.Delete(ctx, u.ID); err != nil { return userError(c, err) }
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// stepUpToken reads the step_up_token of a StepUpReq body.
+func stepUpToken(c *fiber.Ctx) (string, bool) {
+	var req StepUpReq
+	if err := c.BodyParser(&req); err != nil || req.StepUpToken == "" { return "", false }
+	return req.StepUpToken, true
+}
+
+// checkStepUp verifies a step-up token for purpose: issued to the caller, not redeemed and
+// not older than a revoke-sessions. On refusal the proof is nil, with the status and message.
+func (h *UserHandler) checkStepUp(c *fiber.Ctx, tok, purpose string) (*jwtutil.StepUp, int, string) {
+	proof, err := h.Verifier.VerifyStepUp(tok, purpose)
+	if err != nil || proof.UserID != middleware.Principal(c).UserID { return nil, http.StatusForbidden, "invalid step-up token" }
+	revoked, err := h.Revocations.IsRevoked(c.Context(), proof.TokenID, proof.UserID, proof.IssuedAt)
+	if err != nil { return nil, http.StatusServiceUnavailable, "revocation check failed" }
+	if revoked { return nil, http.StatusForbidden, "step-up token already used" }
+	return proof, 0, ""
+}
+
+// redeem revokes step-up tokens so each works only once.
+func (h *UserHandler) redeem(ctx context.Context, proofs ...*jwtutil.StepUp) error {
+	for _, p := range proofs {
+		if err := h.Revocations.RevokeToken(ctx, p.TokenID, p.ExpiresAt); err != nil { return err }
+	}
+	return nil
+}
+
+// endSessions revokes every refresh token of the user and every access token issued so far.
+func (h *UserHandler) endSessions(ctx context.Context, userID string) error {
+	now := time.Now().UTC()
+	if err := h.RefreshTokens.RevokeAllForUser(ctx, userID, now); err != nil { return err }
+	return h.Revocations.RevokeUser(ctx, userID, now)
+}
+
+// normalize trims and validates the request; msg is non-empty when it is rejected.
+func (r UpdateMeReq) normalize() (p user.ProfileUpdate, msg string) {
+	if r.DisplayName != nil {
+		v := strings.TrimSpace(*r.DisplayName)
+		if utf8.RuneCountInString(v) > maxDisplayName { return p, "display_name too long" }
+		if strings.IndexFunc(v, unicode.IsControl) >= 0 { return p, "invalid display_name" }
+		p.DisplayName = &v
+	}
+	if r.Email != nil {
+		v := strings.TrimSpace(*r.Email)
+		if v != "" {
+			a, err := mail.ParseAddress(v)
+			if err != nil || a.Address != v || len(v) > 254 { return p, "invalid email" }
+		}
+		p.Email = &v
+	}
+	if r.Locale != nil {
+		v := strings.TrimSpace(*r.Locale)
+		if v != "" && !localeRx.MatchString(v) { return p, "invalid locale" }
+		p.Locale = &v
+	}
+	return p, ""
+}
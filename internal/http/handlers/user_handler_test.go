@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	memphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/memory"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+)
+
+func TestStepUpConsumers(t *testing.T) {
+	ctx := context.Background()
+	keys := jwtutil.NewHMACKeySet("secret")
+	signer := &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"}
+	users := memory.NewUserRepo()
+	refresh := memory.NewRefreshRepo(ctx, 0)
+	const testPhone = "+989990000001"
+	list, err := phonelist.New(memphonelist.NewStore(), []phonelist.Entry{{Pattern: testPhone, Kind: phonelist.KindTest, Code: "424242"}}, otp.Format{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &UserHandler{
+		Users:         users,
+		PhoneList:     list,
+		Verifier:      jwtutil.NewVerifier(keys, "iss", "aud", 0),
+		RefreshTokens: refresh,
+		Revocations:   memrevocation.NewStore(time.Hour),
+	}
+	app := fiber.New()
+	me := app.Group("/me", middleware.Auth(h.Verifier, h.Revocations, users))
+	me.Delete("", h.DeleteMe)
+	me.Put("/phone", h.ChangePhone)
+
+	const alicePhone, bobPhone, newPhone = "+989121111111", "+989122222222", "+989123333333"
+	for _, u := range []user.User{{ID: "alice", Phone: alicePhone}, {ID: "bob", Phone: bobPhone}} {
+		if err := users.Create(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	access := func(id string) string {
+		tok, err := signer.Access(id, user.RoleUser, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	stepUp := func(id, phone, purpose string) string {
+		tok, err := signer.StepUp(id, phone, purpose, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	call := func(method, path, bearer, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	del := func(tok string) string { return `{"step_up_token":"` + tok + `"}` }
+	change := func(next, current string) string {
+		return `{"step_up_token":"` + next + `","current_step_up_token":"` + current + `"}`
+	}
+	aliceCurrent := func() string { return stepUp("alice", alicePhone, otp.PurposeChangePhone) }
+
+	// sessions from before the phone change; they all end with it
+	alice := access("alice")
+	if err := refresh.Create(ctx, &token.RefreshToken{ID: "rt", FamilyID: "rt", UserID: "alice", TokenHash: "alice-session", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	replayed := stepUp("alice", newPhone, otp.PurposeChangePhone)
+	replayedCurrent := aliceCurrent()
+	for _, tc := range []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"no token", "DELETE", "/me", del(""), http.StatusBadRequest},
+		{"no current phone token", "PUT", "/me/phone", change(stepUp("alice", newPhone, otp.PurposeChangePhone), ""), http.StatusBadRequest},
+		{"wrong purpose", "DELETE", "/me", del(stepUp("alice", alicePhone, otp.PurposeChangePhone)), http.StatusForbidden},
+		{"login purpose", "PUT", "/me/phone", change(stepUp("alice", newPhone, otp.PurposeLogin), aliceCurrent()), http.StatusForbidden},
+		{"access token as step-up", "PUT", "/me/phone", change(alice, aliceCurrent()), http.StatusForbidden},
+		// bob passed an OTP on alice's phone: his token is no use to alice
+		{"issued to another user", "DELETE", "/me", del(stepUp("bob", alicePhone, otp.PurposeDeleteAccount)), http.StatusForbidden},
+		{"someone else's phone", "DELETE", "/me", del(stepUp("alice", bobPhone, otp.PurposeDeleteAccount)), http.StatusForbidden},
+		{"current phone not proven", "PUT", "/me/phone", change(stepUp("alice", newPhone, otp.PurposeChangePhone), stepUp("alice", bobPhone, otp.PurposeChangePhone)), http.StatusForbidden},
+		{"same phone", "PUT", "/me/phone", change(aliceCurrent(), aliceCurrent()), http.StatusBadRequest},
+		// anyone knowing the fixed code could log in to it
+		{"test number", "PUT", "/me/phone", change(stepUp("alice", testPhone, otp.PurposeChangePhone), aliceCurrent()), http.StatusForbidden},
+		{"phone taken", "PUT", "/me/phone", change(stepUp("alice", bobPhone, otp.PurposeChangePhone), aliceCurrent()), http.StatusConflict},
+		{"change phone", "PUT", "/me/phone", change(replayed, replayedCurrent), http.StatusOK},
+		{"sessions ended", "PUT", "/me/phone", change(replayed, replayedCurrent), http.StatusUnauthorized},
+	} {
+		if got := call(tc.method, tc.path, alice, tc.body); got != tc.want {
+			t.Errorf("%s: %s %s = %d, want %d", tc.name, tc.method, tc.path, got, tc.want)
+		}
+	}
+	if rt, _ := refresh.GetByHash(ctx, "alice-session"); rt == nil || rt.RevokedAt == nil {
+		t.Errorf("refresh token of alice not revoked by the phone change: %+v", rt)
+	}
+
+	// a new login after the change, past the revocation second; step-up tokens work once
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	alice = access("alice")
+	if got := call("PUT", "/me/phone", alice, change(replayed, replayedCurrent)); got != http.StatusForbidden {
+		t.Errorf("replayed change_phone tokens = %d, want 403", got)
+	}
+	if got := call("DELETE", "/me", alice, del(stepUp("alice", alicePhone, otp.PurposeDeleteAccount))); got != http.StatusForbidden {
+		t.Errorf("delete with the old phone = %d, want 403", got)
+	}
+	if got := call("DELETE", "/me", alice, del(stepUp("alice", newPhone, otp.PurposeDeleteAccount))); got != http.StatusNoContent {
+		t.Errorf("delete = %d, want 204", got)
+	}
+	if got := call("DELETE", "/me", alice, del(stepUp("alice", newPhone, otp.PurposeDeleteAccount))); got != http.StatusUnauthorized {
+		t.Errorf("delete of a deleted user = %d, want 401", got)
+	}
+	if _, err := users.GetByPhone(ctx, alicePhone); err != user.ErrNotFound {
+		t.Errorf("old phone still mapped: %v", err)
+	}
+	if u, err := users.GetByID(ctx, "bob"); err != nil || u.Phone != bobPhone {
+		t.Errorf("bob = %+v, %v", u, err)
+	}
+}
+
+func TestMe(t *testing.T) {
+	ctx := context.Background()
+	keys := jwtutil.NewHMACKeySet("secret")
+	signer := &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"}
+	users := memory.NewUserRepo()
+	h := &UserHandler{Users: users, Verifier: jwtutil.NewVerifier(keys, "iss", "aud", 0)}
+	app := fiber.New()
+	me := app.Group("/me", middleware.Auth(h.Verifier, memrevocation.NewStore(time.Hour), users))
+	me.Get("", h.GetMe)
+	me.Patch("", h.UpdateMe)
+
+	const phoneNum = "+989121111111"
+	if err := users.Create(ctx, &user.User{ID: "alice", Phone: phoneNum, Role: user.RoleUser, DisplayName: "Alice", Locale: "en"}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := signer.Access("alice", user.RoleUser, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(method, body string) (int, user.User) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/me", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+tok)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var u user.User
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, u
+	}
+
+	if status, u := call("GET", ""); status != http.StatusOK || u.ID != "alice" || u.Phone != phoneNum || u.DisplayName != "Alice" {
+		t.Fatalf("GET /me = %d %+v", status, u)
+	}
+
+	for _, tc := range []struct {
+		name, body string
+		want       int
+	}{
+		{"display name of 65 runes", `{"display_name":"` + strings.Repeat("ش", maxDisplayName+1) + `"}`, http.StatusBadRequest},
+		{"control character in display name", `{"display_name":"a\nb"}`, http.StatusBadRequest},
+		{"not an email", `{"email":"alice"}`, http.StatusBadRequest},
+		{"email with a display name", `{"email":"Alice <alice@example.com>"}`, http.StatusBadRequest},
+		{"email too long", `{"email":"` + strings.Repeat("a", 250) + `@example.com"}`, http.StatusBadRequest},
+		{"underscore locale", `{"locale":"fa_IR"}`, http.StatusBadRequest},
+		{"one letter locale", `{"locale":"f"}`, http.StatusBadRequest},
+		// one bad field rejects the whole update
+		{"valid name, invalid email", `{"display_name":"Bob","email":"bad"}`, http.StatusBadRequest},
+		{"not json", `{`, http.StatusBadRequest},
+	} {
+		if status, _ := call("PATCH", tc.body); status != tc.want {
+			t.Errorf("%s: PATCH /me = %d, want %d", tc.name, status, tc.want)
+		}
+	}
+	if _, u := call("GET", ""); u.DisplayName != "Alice" || u.Email != "" || u.Locale != "en" {
+		t.Fatalf("rejected updates changed the profile: %+v", u)
+	}
+
+	// fields outside the profile are ignored, omitted ones kept, "" clears
+	status, u := call("PATCH", `{"display_name":"  `+strings.Repeat("ش", maxDisplayName)+`  ","email":"alice@example.com","locale":"",
+		"phone":"+989129999999","role":"admin","id":"mallory","totp_enabled":true}`)
+	if status != http.StatusOK {
+		t.Fatalf("PATCH /me = %d", status)
+	}
+	if u.DisplayName != strings.Repeat("ش", maxDisplayName) || u.Email != "alice@example.com" || u.Locale != "" {
+		t.Errorf("profile = %+v", u)
+	}
+	stored, err := users.GetByID(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Phone != phoneNum || stored.Role != user.RoleUser || stored.TOTPEnabled || u.ID != "alice" {
+		t.Errorf("PATCH /me changed protected fields: %+v", stored)
+	}
+	if status, u := call("PATCH", `{"locale":"fa-IR"}`); status != http.StatusOK || u.Locale != "fa-IR" || u.Email != "alice@example.com" {
+		t.Errorf("locale only = %d %+v", status, u)
+	}
+}
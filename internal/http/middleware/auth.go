@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+)
+
+const principalKey = "principal"
+
+// Auth requires a valid bearer access token that is not revoked and whose user still exists.
+// The caller is available to handlers through Principal, with the user's current role.
+func Auth(v *jwtutil.Verifier, revoked revocation.Store, users user.Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		h := c.Get("Authorization")
+		if !strings.HasPrefix(strings.ToLower(h), "bearer ") {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing bearer"})
+		}
+		p, err := v.Verify(strings.TrimSpace(h[7:]))
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
+		}
+		isRevoked, err := revoked.IsRevoked(c.Context(), p.TokenID, p.UserID, p.IssuedAt)
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "revocation check failed"})
+		}
+		if isRevoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "token revoked"})
+		}
+		u, err := users.GetByID(context.Background(), p.UserID)
+		if errors.Is(err, user.ErrNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unknown user"})
+		} else if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "storage unavailable"})
+		}
+		// authorize on the stored role: the claim is only what it was at issue time
+		p.Role = u.Role
+		c.Locals(principalKey, p)
+		return c.Next()
+	}
+}
+
+// Principal returns the caller authenticated by Auth, or nil on public routes.
+func Principal(c *fiber.Ctx) *jwtutil.Principal {
+	p, _ := c.Locals(principalKey).(*jwtutil.Principal)
+	return p
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRole lets the request through only if the caller's role is one of roles.
+// Must run after Auth.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if p := Principal(c); p == nil || !slices.Contains(roles, p.Role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "forbidden"})
+		}
+		return c.Next()
+	}
+}
+
+// SelfOrRole allows callers acting on their own user (route param `param`)
+// or holding one of roles. Must run after Auth.
+func SelfOrRole(param string, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := Principal(c)
+		if p == nil || (c.Params(param) != p.UserID && !slices.Contains(roles, p.Role)) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "forbidden"})
+		}
+		return c.Next()
+	}
+}
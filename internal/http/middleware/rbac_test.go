@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+	"github.com/TheAmirMohammad/otp-service/internal/infra/memory"
+	jwtutil "github.com/TheAmirMohammad/otp-service/internal/jwt"
+	memrevocation "github.com/TheAmirMohammad/otp-service/internal/revocation/memory"
+)
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+	keys := jwtutil.NewHMACKeySet("secret")
+	signer := &jwtutil.Signer{Keys: keys, Issuer: "iss", Audience: "aud"}
+	users := memory.NewUserRepo()
+	for _, u := range []user.User{
+		{ID: "alice", Phone: "+989121111111", Role: user.RoleUser},
+		{ID: "root", Phone: "+989122222222", Role: user.RoleAdmin},
+	} {
+		if err := users.Create(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) }
+	app := fiber.New()
+	app.Get("/bare", RequireRole(user.RoleAdmin), ok) // misconfigured: no Auth in front
+	g := app.Group("", Auth(jwtutil.NewVerifier(keys, "iss", "aud", 0), memrevocation.NewStore(time.Minute), users))
+	g.Get("/admin", RequireRole(user.RoleAdmin), ok)
+	g.Get("/users/:id", SelfOrRole("id", user.RoleAdmin), ok)
+
+	token := func(id, role string) string {
+		tok, err := signer.Access(id, role, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	for _, tc := range []struct {
+		name, path, token string
+		want              int
+	}{
+		{"admin", "/admin", token("root", user.RoleAdmin), http.StatusOK},
+		{"user", "/admin", token("alice", user.RoleUser), http.StatusForbidden},
+		// a role claim the stored user does not have grants nothing
+		{"user with admin claim", "/admin", token("alice", user.RoleAdmin), http.StatusForbidden},
+		{"demoted admin claim", "/users/root", token("alice", user.RoleAdmin), http.StatusForbidden},
+		{"admin with stale user claim", "/admin", token("root", user.RoleUser), http.StatusOK},
+		{"no token", "/admin", "", http.StatusUnauthorized},
+		{"no principal", "/bare", token("root", user.RoleAdmin), http.StatusForbidden},
+		{"self", "/users/alice", token("alice", user.RoleUser), http.StatusOK},
+		{"other user", "/users/root", token("alice", user.RoleUser), http.StatusForbidden},
+		{"admin on other user", "/users/alice", token("root", user.RoleAdmin), http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Fatalf("GET %s = %d, want %d", tc.path, resp.StatusCode, tc.want)
+			}
+		})
+	}
+}
@@ -2,40 +2,58 @@
 package httpapi
 
 import (
-	"strings"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/swagger"
-	"github.com/golang-jwt/jwt/v5"
 
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
 	"github.com/TheAmirMohammad/otp-service/internal/http/handlers"
+	"github.com/TheAmirMohammad/otp-service/internal/http/middleware"
 )
 
-func New(app *fiber.App, ah *handlers.AuthHandler, uh *handlers.UserHandler) {
+func New(app *fiber.App, ah *handlers.AuthHandler, uh *handlers.UserHandler, th *handlers.TOTPHandler, adm *handlers.AdminHandler) {
 	api := app.Group("/api/v1")
 
 	//Auth endpoints
 	api.Post("/auth/request-otp", ah.RequestOTP)
 	api.Post("/auth/verify-otp", ah.VerifyOTP)
-	
-	protected := api.Group("", func(c *fiber.Ctx) error {
-		h := c.Get("Authorization")
-		if !strings.HasPrefix(strings.ToLower(h), "bearer ") {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error":"missing bearer"})
-		}
-		tok := strings.TrimSpace(h[7:])
-		t, err := jwt.Parse(tok, func(t *jwt.Token) (any, error) {
-			return []byte(ah.JWTSecret), nil
-		})
-		if err != nil || !t.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error":"invalid token"})
-		}
-		return c.Next()
-	})
+	api.Post("/auth/verify-totp", ah.VerifyTOTP)
+	api.Post("/auth/refresh", ah.Refresh)
+
+	protected := api.Group("", middleware.Auth(ah.Verifier, ah.Revocations, ah.Users))
+
+	protected.Post("/auth/logout", ah.Logout)
+
+	//TOTP enrollment
+	protected.Post("/auth/totp/enroll", th.Enroll)
+	protected.Post("/auth/totp/confirm", th.Confirm)
+	protected.Post("/auth/totp/disable", th.Disable)
 
 	//User endpoints
-	protected.Get("/users/:id", uh.GetUser)
-	protected.Get("/users", uh.ListUsers)
-	
+	protected.Get("/me", uh.GetMe)
+	protected.Patch("/me", uh.UpdateMe)
+	protected.Delete("/me", uh.DeleteMe)
+	protected.Put("/me/phone", uh.ChangePhone)
+	protected.Get("/users/:id", middleware.SelfOrRole("id", user.RoleAdmin), uh.GetUser)
+	protected.Get("/users", middleware.RequireRole(user.RoleAdmin), uh.ListUsers)
+
+	//Admin endpoints
+	admin := protected.Group("/admin", middleware.RequireRole(user.RoleAdmin))
+	admin.Post("/users/:id/revoke-sessions", adm.RevokeSessions)
+	admin.Put("/users/:id/role", adm.SetRole)
+	admin.Get("/otp/phones/:phone", adm.GetPhoneOTP)
+	admin.Delete("/otp/phones/:phone", adm.CancelPhoneOTP)
+	admin.Delete("/otp/phones/:phone/rate-limit", adm.ResetPhoneRateLimit)
+	admin.Get("/otp/ips/:ip", adm.GetIPRateLimit)
+	admin.Delete("/otp/ips/:ip/rate-limit", adm.ResetIPRateLimit)
+	admin.Get("/phone-list", adm.ListPhoneList)
+	admin.Put("/phone-list", adm.PutPhoneListEntry)
+	admin.Delete("/phone-list/:pattern", adm.DeletePhoneListEntry)
+
+	// Public verification keys for other services (empty when signing with HS256)
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(ah.Tokens.Keys.JWKS())
+	})
+
 	app.Get("/swagger/*", swagger.HandlerDefault)
 }
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+)
+
+type RefreshRepo struct {
+	mu     sync.Mutex
+	byID   map[string]token.RefreshToken
+	byHash map[string]string
+}
+
+// NewRefreshRepo drops expired tokens every sweep (<= 0 disables) until ctx is done;
+// they are refused anyway, so only reuse detection of dead tokens is lost.
+func NewRefreshRepo(ctx context.Context, sweep time.Duration) *RefreshRepo {
+	r := &RefreshRepo{byID: map[string]token.RefreshToken{}, byHash: map[string]string{}}
+	if sweep > 0 {
+		go func() {
+			t := time.NewTicker(sweep)
+			defer t.Stop()
+			for {
+				select {
+				case now := <-t.C:
+					r.prune(now)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return r
+}
+
+func (r *RefreshRepo) Create(ctx context.Context, t *token.RefreshToken) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	r.byID[t.ID] = *t
+	r.byHash[t.TokenHash] = t.ID
+	return nil
+}
+
+func (r *RefreshRepo) GetByHash(ctx context.Context, hash string) (*token.RefreshToken, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	id, ok := r.byHash[hash]
+	if !ok { return nil, nil }
+	t := r.byID[id]
+	return &t, nil
+}
+
+func (r *RefreshRepo) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	t, ok := r.byID[id]
+	if !ok || t.UsedAt != nil || t.RevokedAt != nil { return false, nil }
+	t.UsedAt = &at
+	r.byID[id] = t
+	return true, nil
+}
+
+func (r *RefreshRepo) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	for id, t := range r.byID {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+			r.byID[id] = t
+		}
+	}
+	return nil
+}
+
+func (r *RefreshRepo) RevokeAllForUser(ctx context.Context, userID string, at time.Time) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	for id, t := range r.byID {
+		if t.UserID == userID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+			r.byID[id] = t
+		}
+	}
+	return nil
+}
+
+// prune removes the tokens expired at now.
+func (r *RefreshRepo) prune(now time.Time) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	for id, t := range r.byID {
+		if now.After(t.ExpiresAt) {
+			delete(r.byID, id)
+			delete(r.byHash, t.TokenHash)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+)
+
+func TestRefreshRepoPrune(t *testing.T) {
+	ctx := context.Background()
+	r := NewRefreshRepo(ctx, 0)
+	now := time.Now()
+	for _, tk := range []token.RefreshToken{
+		{ID: "live", TokenHash: "h1", ExpiresAt: now.Add(time.Hour)},
+		{ID: "dead", TokenHash: "h2", ExpiresAt: now.Add(-time.Second)},
+	} {
+		if err := r.Create(ctx, &tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.prune(now)
+	if tk, _ := r.GetByHash(ctx, "h1"); tk == nil {
+		t.Fatal("live token pruned")
+	}
+	if tk, _ := r.GetByHash(ctx, "h2"); tk != nil {
+		t.Fatal("expired token kept")
+	}
+	if len(r.byID) != 1 {
+		t.Fatalf("%d tokens left, want 1", len(r.byID))
+	}
+}
+
+func TestRefreshRepoSweeper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewRefreshRepo(ctx, time.Millisecond)
+	if err := r.Create(ctx, &token.RefreshToken{ID: "dead", TokenHash: "h", ExpiresAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if tk, _ := r.GetByHash(ctx, "h"); tk == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired token not swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -25,22 +25,41 @@ func (r *UserRepo) Create(ctx context.Context, u *user.User) error {
 	r.mu.Lock(); defer r.mu.Unlock()
 	if u.ID == "" { u.ID = uuid.NewString() }
 	if u.RegisteredAt.IsZero() { u.RegisteredAt = time.Now().UTC() }
+	if u.Role == "" { u.Role = user.RoleUser }
+	if _, ok := r.byID[u.ID]; ok { return user.ErrConflict }
+	if _, ok := r.byPhone[u.Phone]; ok { return user.ErrConflict }
 	r.byID[u.ID] = *u
 	r.byPhone[u.Phone] = u.ID
 	return nil
 }
 
+func (r *UserRepo) FindOrCreateByPhone(ctx context.Context, u *user.User) (*user.User, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	if id, ok := r.byPhone[u.Phone]; ok {
+		existing := r.byID[id]
+		return &existing, nil
+	}
+	nu := *u
+	if nu.ID == "" { nu.ID = uuid.NewString() }
+	if nu.RegisteredAt.IsZero() { nu.RegisteredAt = time.Now().UTC() }
+	if nu.Role == "" { nu.Role = user.RoleUser }
+	if _, ok := r.byID[nu.ID]; ok { return nil, user.ErrConflict }
+	r.byID[nu.ID] = nu
+	r.byPhone[nu.Phone] = nu.ID
+	return &nu, nil
+}
+
 func (r *UserRepo) GetByID(ctx context.Context, id string) (*user.User, error) {
 	r.mu.RLock(); defer r.mu.RUnlock()
 	u, ok := r.byID[id]
-	if !ok { return nil, nil }
+	if !ok { return nil, user.ErrNotFound }
 	return &u, nil
 }
 
 func (r *UserRepo) GetByPhone(ctx context.Context, phone string) (*user.User, error) {
 	r.mu.RLock(); defer r.mu.RUnlock()
 	id, ok := r.byPhone[phone]
-	if !ok { return nil, nil }
+	if !ok { return nil, user.ErrNotFound }
 	u := r.byID[id]
 	return &u, nil
 }
@@ -58,4 +77,65 @@ func (r *UserRepo) List(ctx context.Context, f user.ListFilter) ([]user.User, in
 	start := min(f.Offset, total)
 	end := min(start + f.Limit, total)
 	return out[start:end], total, nil
-}
\ No newline at end of file
+}
+
+func (r *UserRepo) SetTOTP(ctx context.Context, id, secret string, enabled bool) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return user.ErrNotFound }
+	u.TOTPSecret, u.TOTPEnabled = secret, enabled
+	r.byID[id] = u
+	return nil
+}
+
+func (r *UserRepo) AdvanceTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return false, user.ErrNotFound }
+	if step <= u.TOTPLastStep { return false, nil }
+	u.TOTPLastStep = step
+	r.byID[id] = u
+	return true, nil
+}
+
+func (r *UserRepo) SetRole(ctx context.Context, id, role string) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return user.ErrNotFound }
+	u.Role = role
+	r.byID[id] = u
+	return nil
+}
+
+func (r *UserRepo) UpdateProfile(ctx context.Context, id string, p user.ProfileUpdate) (*user.User, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return nil, user.ErrNotFound }
+	if p.DisplayName != nil { u.DisplayName = *p.DisplayName }
+	if p.Email != nil { u.Email = *p.Email }
+	if p.Locale != nil { u.Locale = *p.Locale }
+	r.byID[id] = u
+	return &u, nil
+}
+
+func (r *UserRepo) SetPhone(ctx context.Context, id, phone string) (*user.User, error) {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return nil, user.ErrNotFound }
+	if owner, taken := r.byPhone[phone]; taken && owner != id { return nil, user.ErrConflict }
+	delete(r.byPhone, u.Phone)
+	u.Phone = phone
+	u.TestAccount = false
+	r.byID[id] = u
+	r.byPhone[phone] = id
+	return &u, nil
+}
+
+func (r *UserRepo) Delete(ctx context.Context, id string) error {
+	r.mu.Lock(); defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok { return user.ErrNotFound }
+	delete(r.byID, id)
+	delete(r.byPhone, u.Phone)
+	return nil
+}
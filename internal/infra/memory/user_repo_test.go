@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+)
+
+func TestFindOrCreateByPhoneRace(t *testing.T) {
+	ctx := context.Background()
+	r := NewUserRepo()
+	const n = 50
+	var (
+		wg  sync.WaitGroup
+		ids [n]string
+	)
+	start := make(chan struct{})
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			u, err := r.FindOrCreateByPhone(ctx, &user.User{ID: fmt.Sprintf("id-%d", i), Phone: "+989121111111"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids[i] = u.ID
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("call %d got user %q, call 0 got %q", i, id, ids[0])
+		}
+	}
+	if _, total, _ := r.List(ctx, user.ListFilter{}); total != 1 {
+		t.Fatalf("%d users created, want 1", total)
+	}
+	u, err := r.GetByPhone(ctx, "+989121111111")
+	if err != nil || u.ID != ids[0] || u.Role != user.RoleUser || u.RegisteredAt.IsZero() {
+		t.Fatalf("GetByPhone = %+v, %v", u, err)
+	}
+}
+
+func TestFindOrCreateByPhoneIDTaken(t *testing.T) {
+	ctx := context.Background()
+	r := NewUserRepo()
+	if err := r.Create(ctx, &user.User{ID: "alice", Phone: "+989121111111"}); err != nil {
+		t.Fatal(err)
+	}
+	// an existing phone wins over the proposed id
+	if u, err := r.FindOrCreateByPhone(ctx, &user.User{ID: "other", Phone: "+989121111111"}); err != nil || u.ID != "alice" {
+		t.Fatalf("existing phone = %+v, %v", u, err)
+	}
+	if _, err := r.FindOrCreateByPhone(ctx, &user.User{ID: "alice", Phone: "+989122222222"}); err != user.ErrConflict {
+		t.Fatalf("taken id = %v, want ErrConflict", err)
+	}
+}
+
+func TestAdvanceTOTPStep(t *testing.T) {
+	ctx := context.Background()
+	r := NewUserRepo()
+	if err := r.Create(ctx, &user.User{ID: "alice", Phone: "+989121111111"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		id   string
+		step int64
+		want bool
+		err  error
+	}{
+		{"first code", "alice", 10, true, nil},
+		{"replay", "alice", 10, false, nil},
+		{"older step", "alice", 9, false, nil},
+		{"next step", "alice", 11, true, nil},
+		{"no such user", "bob", 12, false, user.ErrNotFound},
+	} {
+		if got, err := r.AdvanceTOTPStep(ctx, tc.id, tc.step); got != tc.want || err != tc.err {
+			t.Errorf("%s: AdvanceTOTPStep = %v, %v, want %v, %v", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
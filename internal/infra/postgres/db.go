@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/TheAmirMohammad/otp-service/migrations"
 )
 
 // Non-fatal connect: returns error instead of exiting
@@ -20,15 +22,14 @@ func Connect(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 	return db, nil
 }
 
-// Non-fatal migrate: returns error instead of exiting
+// Migrate applies the pending embedded migrations (see package migrations).
+// Non-fatal: returns error instead of exiting.
 func Migrate(ctx context.Context, db *pgxpool.Pool) error {
-	const q = `
-CREATE TABLE IF NOT EXISTS users (
-  id TEXT PRIMARY KEY,
-  phone TEXT NOT NULL UNIQUE,
-  registered_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);`
-	if _, err := db.Exec(ctx, q); err != nil {
+	m, err := NewMigrator(db, migrations.FS)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
 	return nil
@@ -0,0 +1,240 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrateLockID is the pg_advisory_lock key serializing migrations across replicas.
+const migrateLockID = 7_315_001
+
+var migrationRx = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version  int64
+	Name     string
+	Up, Down string
+	Checksum string // SHA-256 of Up, recorded when applied
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	At       time.Time
+	Checksum string // "" for rows recorded before checksums were kept
+}
+
+// MigrationStatus describes one known migration.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time // nil while pending
+}
+
+// Migrator applies the numbered up/down SQL files of a directory, recording
+// applied versions in schema_migrations. Each migration runs in its own transaction.
+// Up refuses to run when an applied file was edited since, or when a pending
+// migration is numbered below one already applied.
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []migration // sorted by version
+}
+
+// NewMigrator reads NNN_name.up.sql / NNN_name.down.sql pairs from fsys.
+func NewMigrator(db *pgxpool.Pool, fsys fs.FS) (*Migrator, error) {
+	ms, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+// loadMigrations reads the migrations of fsys, sorted by version.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+	byVersion := map[int64]*migration{}
+	for _, f := range files {
+		m := migrationRx.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		v, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", f.Name(), err)
+		}
+		mg := byVersion[v]
+		if mg == nil {
+			mg = &migration{Version: v, Name: m[2]}
+			byVersion[v] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s, %s", v, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(body)
+			sum := sha256.Sum256(body)
+			mg.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mg.Down = string(body)
+		}
+	}
+	var out []migration
+	for _, mg := range byVersion {
+		if mg.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", mg.Version, mg.Name)
+		}
+		out = append(out, *mg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// pending returns the migrations of ms not in applied, in order. It fails when an
+// applied migration's file no longer matches its recorded checksum, or when a pending
+// one is numbered below the latest applied version (it would run out of order).
+// Applied versions unknown to ms come from a newer build and are left alone.
+func pending(ms []migration, applied map[int64]appliedMigration) ([]migration, error) {
+	var latest int64
+	for v := range applied {
+		latest = max(latest, v)
+	}
+	var out []migration
+	for _, mg := range ms {
+		a, ok := applied[mg.Version]
+		switch {
+		case ok && a.Checksum != "" && a.Checksum != mg.Checksum:
+			return nil, fmt.Errorf("migration %d_%s was edited after it was applied: add a new migration instead", mg.Version, mg.Name)
+		case ok:
+			continue
+		case mg.Version < latest:
+			return nil, fmt.Errorf("migration %d_%s is pending but %d is already applied: renumber it after the latest", mg.Version, mg.Name, latest)
+		}
+		out = append(out, mg)
+	}
+	return out, nil
+}
+
+// Up applies all pending migrations and returns the versions it applied.
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	var done []int64
+	err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int64]appliedMigration) error {
+		todo, err := pending(m.migrations, applied)
+		if err != nil {
+			return err
+		}
+		for _, mg := range todo {
+			if err := m.apply(ctx, conn, mg.Up,
+				`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, mg.Version, mg.Name, mg.Checksum); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the n most recently applied migrations and returns their versions.
+func (m *Migrator) Down(ctx context.Context, n int) ([]int64, error) {
+	var done []int64
+	err := m.locked(ctx, func(conn *pgxpool.Conn, applied map[int64]appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < n; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+			if mg.Down == "" {
+				return fmt.Errorf("migration %d_%s: no down file", mg.Version, mg.Name)
+			}
+			if err := m.apply(ctx, conn, mg.Down,
+				`DELETE FROM schema_migrations WHERE version=$1`, mg.Version); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+			}
+			done = append(done, mg.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var out []MigrationStatus
+	err := m.locked(ctx, func(_ *pgxpool.Conn, applied map[int64]appliedMigration) error {
+		for _, mg := range m.migrations {
+			st := MigrationStatus{Version: mg.Version, Name: mg.Name}
+			if a, ok := applied[mg.Version]; ok {
+				st.AppliedAt = &a.At
+			}
+			out = append(out, st)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// locked runs fn on one connection holding the advisory lock, after making
+// sure schema_migrations exists and loading the applied versions.
+func (m *Migrator) locked(ctx context.Context, fn func(*pgxpool.Conn, map[int64]appliedMigration) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire conn: %w", err)
+	}
+	defer conn.Release()
+
+	// session-level lock: must be released on the same connection
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrateLockID); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrateLockID)
+
+	if _, err := conn.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT ''`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	rows, err := conn.Query(ctx, `SELECT version, applied_at, checksum FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	applied := map[int64]appliedMigration{}
+	var (
+		v int64
+		a appliedMigration
+	)
+	if _, err := pgx.ForEachRow(rows, []any{&v, &a.At, &a.Checksum}, func() error {
+		applied[v] = a
+		return nil
+	}); err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	return fn(conn, applied)
+}
+
+// apply runs sql and the bookkeeping statement in a single transaction.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, sql, record string, args ...any) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+	ms, err := loadMigrations(fstest.MapFS{
+		"010_tenth.up.sql":    file("SELECT 10"),
+		"002_second.up.sql":   file("SELECT 2"),
+		"002_second.down.sql": file("SELECT -2"),
+		"1_first.up.sql":      file("SELECT 1"),
+		"README.md":           file("not a migration"),
+		"003_skip.sql":        file("no direction"),
+		"004_dir.up.sql/x":    file("directories are skipped"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range ms {
+		got = append(got, m.Name)
+	}
+	if strings.Join(got, ",") != "first,second,tenth" {
+		t.Fatalf("order = %v, want first,second,tenth", got)
+	}
+	if ms[1].Up != "SELECT 2" || ms[1].Down != "SELECT -2" || ms[0].Down != "" {
+		t.Errorf("bodies = %+v", ms)
+	}
+	if ms[0].Checksum == "" || ms[0].Checksum == ms[1].Checksum {
+		t.Errorf("checksums = %q, %q", ms[0].Checksum, ms[1].Checksum)
+	}
+
+	for _, tc := range []struct {
+		name string
+		fs   fstest.MapFS
+		want string
+	}{
+		{"down only", fstest.MapFS{"001_a.down.sql": file("x")}, "missing up file"},
+		{"two names", fstest.MapFS{"001_a.up.sql": file("x"), "001_b.down.sql": file("x")}, "two names"},
+	} {
+		if _, err := loadMigrations(tc.fs); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	ms, err := loadMigrations(fstest.MapFS{
+		"001_a.up.sql": {Data: []byte("SELECT 1")},
+		"002_b.up.sql": {Data: []byte("SELECT 2")},
+		"003_c.up.sql": {Data: []byte("SELECT 3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := func(m migration) appliedMigration { return appliedMigration{At: time.Now(), Checksum: m.Checksum} }
+	versions := func(ms []migration) []int64 {
+		var out []int64
+		for _, m := range ms {
+			out = append(out, m.Version)
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name    string
+		applied map[int64]appliedMigration
+		want    []int64
+		err     string
+	}{
+		{"fresh database", nil, []int64{1, 2, 3}, ""},
+		{"applied are skipped", map[int64]appliedMigration{1: done(ms[0]), 2: done(ms[1])}, []int64{3}, ""},
+		{"up to date", map[int64]appliedMigration{1: done(ms[0]), 2: done(ms[1]), 3: done(ms[2])}, nil, ""},
+		{"recorded before checksums", map[int64]appliedMigration{1: {At: time.Now()}}, []int64{2, 3}, ""},
+		{"newer build ran first", map[int64]appliedMigration{1: done(ms[0]), 2: done(ms[1]), 3: done(ms[2]), 4: {At: time.Now(), Checksum: "x"}}, nil, ""},
+		{"edited after applied", map[int64]appliedMigration{1: {At: time.Now(), Checksum: "other"}}, nil, "1_a was edited"},
+		{"out of order", map[int64]appliedMigration{1: done(ms[0]), 3: done(ms[2])}, nil, "2_b is pending but 3 is already applied"},
+	} {
+		got, err := pending(ms, tc.applied)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: err = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if g := versions(got); !slices.Equal(g, tc.want) {
+			t.Errorf("%s: pending = %v, want %v", tc.name, g, tc.want)
+		}
+	}
+}
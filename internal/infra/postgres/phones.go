@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PhoneChange is one users.phone value and its E.164 form ("" when it does not parse).
+type PhoneChange struct {
+	ID, From, To string
+}
+
+// PhoneBackfill reports what BackfillPhones rewrote or, on a dry run, would rewrite.
+type PhoneBackfill struct {
+	Changed []PhoneChange
+	// Users whose phones normalize to the same number; they are left as they are
+	// and have to be merged by hand.
+	Collisions [][]PhoneChange
+	Invalid    []PhoneChange
+}
+
+// BackfillPhones rewrites users.phone rows stored before normalization (0912...,
+// 98912...) to E.164, in one transaction. Rows that would collide are reported,
+// not changed. With apply false nothing is written.
+func BackfillPhones(ctx context.Context, db *pgxpool.Pool, normalize func(string) (string, error), apply bool) (PhoneBackfill, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return PhoneBackfill{}, fmt.Errorf("backfill phones: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id, phone FROM users ORDER BY registered_at FOR UPDATE`)
+	if err != nil {
+		return PhoneBackfill{}, fmt.Errorf("backfill phones: %w", err)
+	}
+	var all []PhoneChange
+	for rows.Next() {
+		var c PhoneChange
+		if err := rows.Scan(&c.ID, &c.From); err != nil {
+			rows.Close()
+			return PhoneBackfill{}, fmt.Errorf("backfill phones: %w", err)
+		}
+		all = append(all, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return PhoneBackfill{}, fmt.Errorf("backfill phones: %w", err)
+	}
+
+	plan := planPhones(all, normalize)
+	if !apply {
+		return plan, nil
+	}
+	for _, c := range plan.Changed {
+		if _, err := tx.Exec(ctx, `UPDATE users SET phone=$2 WHERE id=$1`, c.ID, c.To); err != nil {
+			return PhoneBackfill{}, fmt.Errorf("backfill phones: %s: %w", c.ID, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return PhoneBackfill{}, fmt.Errorf("backfill phones: %w", err)
+	}
+	return plan, nil
+}
+
+// planPhones normalizes every row and splits them into changes, collisions and
+// unparsable phones. Rows already in E.164 that nothing collides with are dropped.
+func planPhones(rows []PhoneChange, normalize func(string) (string, error)) PhoneBackfill {
+	var out PhoneBackfill
+	groups := map[string][]PhoneChange{}
+	var order []string
+	for _, c := range rows {
+		to, err := normalize(c.From)
+		if err != nil {
+			out.Invalid = append(out.Invalid, c)
+			continue
+		}
+		c.To = to
+		if _, ok := groups[to]; !ok {
+			order = append(order, to)
+		}
+		groups[to] = append(groups[to], c)
+	}
+	for _, to := range order {
+		g := groups[to]
+		switch {
+		case len(g) > 1:
+			out.Collisions = append(out.Collisions, g)
+		case g[0].From != g[0].To:
+			out.Changed = append(out.Changed, g[0])
+		}
+	}
+	return out
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanPhones(t *testing.T) {
+	// stand-in for phone.Normalizer with region IR
+	normalize := func(s string) (string, error) {
+		switch {
+		case strings.HasPrefix(s, "+98"):
+			return s, nil
+		case strings.HasPrefix(s, "09"):
+			return "+98" + s[1:], nil
+		case strings.HasPrefix(s, "989"):
+			return "+" + s, nil
+		}
+		return "", errors.New("invalid")
+	}
+	got := planPhones([]PhoneChange{
+		{ID: "a", From: "09121111111"},
+		{ID: "b", From: "+989122222222"},
+		{ID: "c", From: "09122222222"},
+		{ID: "d", From: "989123333333"},
+		{ID: "e", From: "+989124444444"},
+		{ID: "f", From: "garbage"},
+	}, normalize)
+
+	want := PhoneBackfill{
+		Changed: []PhoneChange{
+			{ID: "a", From: "09121111111", To: "+989121111111"},
+			{ID: "d", From: "989123333333", To: "+989123333333"},
+		},
+		Collisions: [][]PhoneChange{{
+			{ID: "b", From: "+989122222222", To: "+989122222222"},
+			{ID: "c", From: "09122222222", To: "+989122222222"},
+		}},
+		Invalid: []PhoneChange{{ID: "f", From: "garbage"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("planPhones =\n%+v\nwant\n%+v", got, want)
+	}
+}
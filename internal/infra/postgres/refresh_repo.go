@@ -0,0 +1,89 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/token"
+)
+
+type RefreshRepo struct{ db *pgxpool.Pool }
+
+// NewRefreshRepo deletes dead tokens every sweep (<= 0 disables) until ctx is done:
+// expired ones, and revoked ones once they have been revoked for longer than retention.
+// Both are refused anyway; only reuse detection of dead tokens is lost.
+func NewRefreshRepo(ctx context.Context, db *pgxpool.Pool, sweep, retention time.Duration) *RefreshRepo {
+	r := &RefreshRepo{db: db}
+	if sweep > 0 {
+		go func() {
+			t := time.NewTicker(sweep)
+			defer t.Stop()
+			for {
+				select {
+				case now := <-t.C:
+					if n, err := r.Prune(ctx, now, retention); err != nil {
+						log.Printf("refresh tokens: prune: %v", err)
+					} else if n > 0 {
+						log.Printf("refresh tokens: pruned %d", n)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return r
+}
+
+func (r *RefreshRepo) Create(ctx context.Context, t *token.RefreshToken) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO refresh_tokens (id, family_id, user_id, token_hash, created_at, expires_at)
+VALUES ($1,$2,$3,$4,$5,$6)`, t.ID, t.FamilyID, t.UserID, t.TokenHash, t.CreatedAt, t.ExpiresAt)
+	return err
+}
+
+func (r *RefreshRepo) GetByHash(ctx context.Context, hash string) (*token.RefreshToken, error) {
+	row := r.db.QueryRow(ctx, `SELECT id, family_id, user_id, token_hash, created_at, expires_at, used_at, revoked_at
+FROM refresh_tokens WHERE token_hash=$1`, hash)
+	var t token.RefreshToken
+	err := row.Scan(&t.ID, &t.FamilyID, &t.UserID, &t.TokenHash, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *RefreshRepo) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE refresh_tokens SET used_at=$2 WHERE id=$1 AND used_at IS NULL AND revoked_at IS NULL`, id, at)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *RefreshRepo) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=$2 WHERE family_id=$1 AND revoked_at IS NULL`, familyID, at)
+	return err
+}
+
+func (r *RefreshRepo) RevokeAllForUser(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=$2 WHERE user_id=$1 AND revoked_at IS NULL`, userID, at)
+	return err
+}
+
+// Prune deletes the tokens expired at now and those revoked before now-retention,
+// and reports how many rows went.
+func (r *RefreshRepo) Prune(ctx context.Context, now time.Time, retention time.Duration) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < $1 OR revoked_at < $2`, now, now.Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
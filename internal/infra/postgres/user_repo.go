@@ -2,9 +2,12 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
@@ -12,35 +15,47 @@ import (
 
 type UserRepo struct{ db *pgxpool.Pool }
 
+// SQLSTATE unique_violation
+const uniqueViolation = "23505"
+
+const userColumns = `id, phone, registered_at, role, display_name, email, locale, totp_secret, totp_enabled, totp_last_step, test_account`
+
 func NewUserRepo(db *pgxpool.Pool) *UserRepo { return &UserRepo{db: db} }
 
 func (r *UserRepo) Create(ctx context.Context, u *user.User) error {
-	_, err := r.db.Exec(ctx, `INSERT INTO users (id, phone, registered_at) VALUES ($1,$2,$3)`,
-		u.ID, u.Phone, u.RegisteredAt)
-	return err
+	if u.Role == "" {
+		u.Role = user.RoleUser
+	}
+	_, err := r.db.Exec(ctx, `INSERT INTO users (id, phone, registered_at, role, test_account) VALUES ($1,$2,$3,$4,$5)`,
+		u.ID, u.Phone, u.RegisteredAt, u.Role, u.TestAccount)
+	return mapUserError(err)
 }
 
-func (r *UserRepo) GetByID(ctx context.Context, id string) (*user.User, error) {
-	row := r.db.QueryRow(ctx, `SELECT id, phone, registered_at FROM users WHERE id=$1`, id)
-	var u user.User
-	if err := row.Scan(&u.ID, &u.Phone, &u.RegisteredAt); err != nil {
-		return nil, nil
+func (r *UserRepo) FindOrCreateByPhone(ctx context.Context, u *user.User) (*user.User, error) {
+	role := u.Role
+	if role == "" {
+		role = user.RoleUser
 	}
-	return &u, nil
+	// the no-op update makes RETURNING yield the existing row on conflict
+	out, err := scanUser(r.db.QueryRow(ctx, `INSERT INTO users (id, phone, registered_at, role, test_account) VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (phone) DO UPDATE SET phone=EXCLUDED.phone
+RETURNING `+userColumns, u.ID, u.Phone, u.RegisteredAt, role, u.TestAccount))
+	return out, mapUserError(err)
+}
+
+func (r *UserRepo) GetByID(ctx context.Context, id string) (*user.User, error) {
+	u, err := scanUser(r.db.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id=$1`, id))
+	return u, mapUserError(err)
 }
 
 func (r *UserRepo) GetByPhone(ctx context.Context, phone string) (*user.User, error) {
-	row := r.db.QueryRow(ctx, `SELECT id, phone, registered_at FROM users WHERE phone=$1`, phone)
-	var u user.User
-	if err := row.Scan(&u.ID, &u.Phone, &u.RegisteredAt); err != nil {
-		return nil, nil
-	}
-	return &u, nil
+	u, err := scanUser(r.db.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE phone=$1`, phone))
+	return u, mapUserError(err)
 }
 
 func (r *UserRepo) List(ctx context.Context, f user.ListFilter) ([]user.User, int, error) {
 	var (
-		q    = `SELECT id, phone, registered_at FROM users`
+		q    = `SELECT ` + userColumns + ` FROM users`
 		args []any
 	)
 	if s := strings.TrimSpace(f.Search); s != "" {
@@ -53,16 +68,19 @@ func (r *UserRepo) List(ctx context.Context, f user.ListFilter) ([]user.User, in
 
 	rows, err := r.db.Query(ctx, q, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("list users: %w", err)
 	}
 	defer rows.Close()
 	var out []user.User
 	for rows.Next() {
-		var u user.User
-		if err := rows.Scan(&u.ID, &u.Phone, &u.RegisteredAt); err != nil {
-			return nil, 0, err
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("list users: %w", err)
 		}
-		out = append(out, u)
+		out = append(out, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list users: %w", err)
 	}
 
 	// total
@@ -78,3 +96,96 @@ func (r *UserRepo) List(ctx context.Context, f user.ListFilter) ([]user.User, in
 	}
 	return out, total, nil
 }
+
+func (r *UserRepo) SetTOTP(ctx context.Context, id, secret string, enabled bool) error {
+	tag, err := r.db.Exec(ctx, `UPDATE users SET totp_secret=$2, totp_enabled=$3 WHERE id=$1`, id, secret, enabled)
+	if err != nil {
+		return mapUserError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return user.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) AdvanceTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE users SET totp_last_step=$2 WHERE id=$1 AND totp_last_step < $2`, id, step)
+	if err != nil {
+		return false, mapUserError(err)
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+	// no row: a replay, or no such user
+	var exists bool
+	if err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)`, id).Scan(&exists); err != nil {
+		return false, mapUserError(err)
+	}
+	if !exists {
+		return false, user.ErrNotFound
+	}
+	return false, nil
+}
+
+func (r *UserRepo) SetRole(ctx context.Context, id, role string) error {
+	tag, err := r.db.Exec(ctx, `UPDATE users SET role=$2 WHERE id=$1`, id, role)
+	if err != nil {
+		return mapUserError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return user.ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdateProfile(ctx context.Context, id string, p user.ProfileUpdate) (*user.User, error) {
+	// NULL parameters (nil fields) keep the current value
+	u, err := scanUser(r.db.QueryRow(ctx, `UPDATE users SET
+  display_name=COALESCE($2, display_name),
+  email=COALESCE($3, email),
+  locale=COALESCE($4, locale)
+WHERE id=$1 RETURNING `+userColumns, id, p.DisplayName, p.Email, p.Locale))
+	return u, mapUserError(err)
+}
+
+func (r *UserRepo) SetPhone(ctx context.Context, id, phone string) (*user.User, error) {
+	// the new phone passed a real OTP (test numbers are refused), so this is no test account anymore
+	u, err := scanUser(r.db.QueryRow(ctx, `UPDATE users SET phone=$2, test_account=FALSE WHERE id=$1 RETURNING `+userColumns, id, phone))
+	return u, mapUserError(err)
+}
+
+func (r *UserRepo) Delete(ctx context.Context, id string) error {
+	// refresh tokens go with it (ON DELETE CASCADE)
+	tag, err := r.db.Exec(ctx, `DELETE FROM users WHERE id=$1`, id)
+	if err != nil {
+		return mapUserError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return user.ErrNotFound
+	}
+	return nil
+}
+
+// mapUserError translates pgx errors into the user package's typed errors.
+func mapUserError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return user.ErrNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == uniqueViolation:
+		return user.ErrConflict
+	default:
+		return fmt.Errorf("users: %w", err)
+	}
+}
+
+// scanUser reads one row selected with userColumns.
+func scanUser(row pgx.Row) (*user.User, error) {
+	var u user.User
+	if err := row.Scan(&u.ID, &u.Phone, &u.RegisteredAt, &u.Role, &u.DisplayName, &u.Email, &u.Locale, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &u.TestAccount); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
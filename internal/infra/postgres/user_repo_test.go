@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/TheAmirMohammad/otp-service/internal/domain/user"
+)
+
+func TestMapUserError(t *testing.T) {
+	down := errors.New("connection refused")
+	for _, tc := range []struct {
+		name string
+		err  error
+		want error // nil: the error must come back wrapped, not typed
+	}{
+		{"no rows", pgx.ErrNoRows, user.ErrNotFound},
+		{"wrapped no rows", fmt.Errorf("scan: %w", pgx.ErrNoRows), user.ErrNotFound},
+		{"unique violation", &pgconn.PgError{Code: uniqueViolation}, user.ErrConflict},
+		{"other constraint", &pgconn.PgError{Code: "23503"}, nil},
+		{"connection", down, nil},
+		{"timeout", context.DeadlineExceeded, nil},
+	} {
+		got := mapUserError(tc.err)
+		if tc.want != nil {
+			if !errors.Is(got, tc.want) {
+				t.Errorf("%s: mapUserError = %v, want %v", tc.name, got, tc.want)
+			}
+			continue
+		}
+		if got == nil || errors.Is(got, user.ErrNotFound) || errors.Is(got, user.ErrConflict) || !errors.Is(got, tc.err) {
+			t.Errorf("%s: mapUserError = %v, want the store error kept", tc.name, got)
+		}
+	}
+	if err := mapUserError(nil); err != nil {
+		t.Errorf("mapUserError(nil) = %v", err)
+	}
+}
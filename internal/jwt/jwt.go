@@ -1,13 +1,70 @@
 package jwtutil
 
 import (
+	"strings"
 	"time"
-	
+
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Token types ("typ" claim)
+const (
+	TypeMFA    = "mfa"     // partial token: OTP passed, TOTP still required
+	TypeStepUp = "step_up" // proof that a user's session passed an OTP on a phone for a purpose other than login
 )
 
-func Generate(secret, userID string, ttl time.Duration) (string, error) {
-	claims := jwt.MapClaims{"sub": userID, "exp": time.Now().Add(ttl).Unix(), "iat": time.Now().Unix()}
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(secret))
+// Claims issued by this service.
+type Claims struct {
+	jwt.RegisteredClaims
+	Type  string `json:"typ,omitempty"`   // empty for access tokens
+	Role  string `json:"role,omitempty"`  // user role, see user.RoleUser/RoleAdmin
+	Scope string `json:"scope,omitempty"` // space separated
+
+	// step-up tokens only
+	Purpose string `json:"purpose,omitempty"` // see otp.Purposes
+	Phone   string `json:"phone,omitempty"`   // E.164 number the OTP went to
+}
+
+// Signer issues tokens for one issuer/audience pair.
+type Signer struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+}
+
+// Access issues an access token; its "jti" lets it be revoked individually.
+func (s *Signer) Access(userID, role string, ttl time.Duration, scopes ...string) (string, error) {
+	c := s.claims(userID, "", ttl, scopes)
+	c.Role = role
+	return s.Keys.Sign(c)
+}
+
+// MFA issues a short-lived partial token that can only be upgraded via TOTP.
+func (s *Signer) MFA(userID string, ttl time.Duration) (string, error) {
+	return s.Keys.Sign(s.claims(userID, TypeMFA, ttl, nil))
+}
+
+// StepUp issues a short-lived token proving that userID (the subject), while logged in,
+// just passed an OTP sent to phone (E.164) for purpose.
+func (s *Signer) StepUp(userID, phone, purpose string, ttl time.Duration) (string, error) {
+	c := s.claims(userID, TypeStepUp, ttl, nil)
+	c.Purpose, c.Phone = purpose, phone
+	return s.Keys.Sign(c)
+}
+
+func (s *Signer) claims(userID, typ string, ttl time.Duration, scopes []string) Claims {
+	now := time.Now()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID,
+			Issuer:    s.Issuer,
+			Audience:  jwt.ClaimStrings{s.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Type:  typ,
+		Scope: strings.Join(scopes, " "),
+	}
 }
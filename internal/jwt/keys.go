@@ -0,0 +1,135 @@
+package jwtutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one signing or verification key, identified by the "kid" header.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private any // nil for verify-only keys
+	public  any // []byte for HS256
+}
+
+// KeySet signs with one active key and verifies with every known key,
+// so keys can be rotated without invalidating tokens already out there.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMACKeySet is the legacy single shared-secret (HS256) setup.
+func NewHMACKeySet(secret string) *KeySet {
+	k := &Key{ID: "hs256", Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+	return &KeySet{signing: k, keys: map[string]*Key{k.ID: k}}
+}
+
+// LoadKeySet reads a PEM private key (RSA => RS256, Ed25519 => EdDSA) used for signing,
+// plus extra PEM public keys still accepted for verification. Entries in verifyFiles are
+// "path" or "kid=path"; without an explicit kid the RFC 7638 thumbprint is used.
+func LoadKeySet(signingFile, signingKID string, verifyFiles []string) (*KeySet, error) {
+	signing, err := loadKey(signingFile, signingKID, true)
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, entry := range verifyFiles {
+		kid, path := "", entry
+		if i := strings.Index(entry, "="); i > 0 {
+			kid, path = entry[:i], entry[i+1:]
+		}
+		k, err := loadKey(path, kid, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ks.keys[k.ID]; dup {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// Sign signs claims with the active key and sets the "kid" header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(ks.signing.Method, claims)
+	t.Header["kid"] = ks.signing.ID
+	return t.SignedString(ks.signing.private)
+}
+
+// Keyfunc picks the verification key by "kid" and refuses any other algorithm than the key's.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok && kid == "" && len(ks.keys) == 1 {
+		k, ok = ks.signing, true // tokens issued before kids were introduced
+	}
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown key id %q", kid)
+	}
+	if t.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("jwt: unexpected signing method %s", t.Method.Alg())
+	}
+	return k.public, nil
+}
+
+// Methods lists the algorithms of all verification keys.
+func (ks *KeySet) Methods() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, k := range ks.keys {
+		if alg := k.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			out = append(out, alg)
+		}
+	}
+	return out
+}
+
+func loadKey(path, kid string, needPrivate bool) (*Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: read key: %w", err)
+	}
+	k := &Key{ID: kid}
+	switch {
+	case tryParse(func() (any, error) { return jwt.ParseRSAPrivateKeyFromPEM(raw) }, &k.private):
+		k.Method, k.public = jwt.SigningMethodRS256, &k.private.(*rsa.PrivateKey).PublicKey
+	case tryParse(func() (any, error) { return jwt.ParseEdPrivateKeyFromPEM(raw) }, &k.private):
+		k.Method, k.public = jwt.SigningMethodEdDSA, k.private.(ed25519.PrivateKey).Public()
+	case !needPrivate && tryParse(func() (any, error) { return jwt.ParseRSAPublicKeyFromPEM(raw) }, &k.public):
+		k.Method = jwt.SigningMethodRS256
+	case !needPrivate && tryParse(func() (any, error) { return jwt.ParseEdPublicKeyFromPEM(raw) }, &k.public):
+		k.Method = jwt.SigningMethodEdDSA
+	default:
+		if needPrivate {
+			return nil, fmt.Errorf("jwt: %s: not an RSA or Ed25519 private key", path)
+		}
+		return nil, fmt.Errorf("jwt: %s: not an RSA or Ed25519 key", path)
+	}
+	if k.ID == "" {
+		if k.ID, err = thumbprint(k.public); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func tryParse(parse func() (any, error), dst *any) bool {
+	v, err := parse()
+	if err != nil {
+		return false
+	}
+	*dst = v
+	return true
+}
+
+var errUnsupportedKey = errors.New("jwt: unsupported key type")
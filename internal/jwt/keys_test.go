@@ -0,0 +1,128 @@
+package jwtutil
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, oldPEM := writeEdKey(t, "old")
+	newKey, _ := writeRSAKey(t)
+	oldPub := filepath.Join(t.TempDir(), "old.pub.pem")
+	if err := os.WriteFile(oldPub, oldPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := LoadKeySet(oldKey, "old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := (&Signer{Keys: before, Issuer: testIssuer, Audience: testAudience}).Access("user-1", "user", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotated: sign with the new key, keep accepting the old one
+	after, err := LoadKeySet(newKey, "new", []string{"old=" + oldPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(after, testIssuer, testAudience, testLeeway)
+	if _, err := v.Verify(issued); err != nil {
+		t.Fatalf("token signed before rotation: %v", err)
+	}
+	fresh, _ := (&Signer{Keys: after, Issuer: testIssuer, Audience: testAudience}).Access("user-1", "user", time.Minute)
+	if _, err := v.Verify(fresh); err != nil {
+		t.Fatalf("token signed after rotation: %v", err)
+	}
+	if _, err := NewVerifier(before, testIssuer, testAudience, testLeeway).Verify(fresh); err == nil {
+		t.Fatal("old key set accepted a token from the new key")
+	}
+
+	// retired: the old key is dropped from the verify list
+	retired, err := LoadKeySet(newKey, "new", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVerifier(retired, testIssuer, testAudience, testLeeway).Verify(issued); err == nil {
+		t.Fatal("retired key still accepted")
+	}
+
+	if _, err := LoadKeySet(newKey, "new", []string{"new=" + oldPub}); err == nil {
+		t.Fatal("duplicate kid accepted")
+	}
+	if _, err := LoadKeySet(oldPub, "", nil); err == nil {
+		t.Fatal("public key accepted as signing key")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edKey, _ := writeEdKey(t, "ed")
+	_, rsPEM := writeRSAKey(t)
+	rsPub := filepath.Join(t.TempDir(), "rs.pub.pem")
+	if err := os.WriteFile(rsPub, rsPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := LoadKeySet(edKey, "", []string{rsPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := ks.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("%d keys published, want 2", len(set.Keys))
+	}
+	if set.Keys[0].Kid > set.Keys[1].Kid {
+		t.Fatal("keys not sorted by kid")
+	}
+	byKty := map[string]JWK{}
+	for _, k := range set.Keys {
+		byKty[k.Kty] = k
+		if k.Use != "sig" || k.Kid == "" {
+			t.Fatalf("key %+v: want use=sig and a kid", k)
+		}
+	}
+	ed, rs := byKty["OKP"], byKty["RSA"]
+	if ed.Alg != "EdDSA" || ed.Crv != "Ed25519" || ed.X == "" || ed.N != "" {
+		t.Fatalf("ed25519 jwk = %+v", ed)
+	}
+	if rs.Alg != "RS256" || rs.N == "" || rs.E != "AQAB" || rs.X != "" {
+		t.Fatalf("rsa jwk = %+v", rs)
+	}
+
+	// default kids are the thumbprints, and tokens carry the published kid
+	signed, _ := ks.Sign(Claims{})
+	header, _, _ := strings.Cut(signed, ".")
+	hdr, _ := base64.RawURLEncoding.DecodeString(header)
+	var h struct{ Kid string }
+	if err := json.Unmarshal(hdr, &h); err != nil || h.Kid != ed.Kid {
+		t.Fatalf("token kid %q, published %q", h.Kid, ed.Kid)
+	}
+
+	// shared secrets are never published
+	if keys := NewHMACKeySet("secret").JWKS().Keys; keys == nil || len(keys) != 0 {
+		t.Fatalf("hmac jwks = %v, want an empty list", keys)
+	}
+	b, _ := json.Marshal(NewHMACKeySet("secret").JWKS())
+	if string(b) != `{"keys":[]}` {
+		t.Fatalf("hmac jwks json = %s", b)
+	}
+}
+
+// RFC 8037 appendix A.3.
+func TestThumbprint(t *testing.T) {
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	got, err := thumbprint(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Fatalf("thumbprint = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package jwtutil
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrWrongTokenType = errors.New("jwt: wrong token type")
+	ErrMissingClaims  = errors.New("jwt: missing required claims")
+)
+
+// Principal is the authenticated caller behind a verified access token.
+type Principal struct {
+	UserID    string
+	TokenID   string
+	Role      string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func (p *Principal) HasScope(scope string) bool { return slices.Contains(p.Scopes, scope) }
+
+// Verifier checks signature, pinned algorithms, issuer, audience and time claims.
+type Verifier struct {
+	keys   *KeySet
+	parser *jwt.Parser
+}
+
+// NewVerifier only accepts the algorithms of keys, so a token can never pick its own (e.g. "none" or HS256 with a public key).
+func NewVerifier(keys *KeySet, issuer, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{keys: keys, parser: jwt.NewParser(
+		jwt.WithValidMethods(keys.Methods()),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)}
+}
+
+// Verify validates an access token and returns its principal.
+func (v *Verifier) Verify(tok string) (*Principal, error) {
+	c, err := v.parse(tok)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != "" {
+		return nil, ErrWrongTokenType
+	}
+	if c.Subject == "" || c.ID == "" || c.IssuedAt == nil {
+		return nil, ErrMissingClaims
+	}
+	return &Principal{
+		UserID:    c.Subject,
+		TokenID:   c.ID,
+		Role:      c.Role,
+		Scopes:    strings.Fields(c.Scope),
+		IssuedAt:  c.IssuedAt.Time,
+		ExpiresAt: c.ExpiresAt.Time,
+	}, nil
+}
+
+// VerifyMFA validates a partial (OTP passed, TOTP pending) token and returns its principal,
+// so it can be checked against revocation like an access token.
+func (v *Verifier) VerifyMFA(tok string) (*Principal, error) {
+	c, err := v.parse(tok)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != TypeMFA {
+		return nil, ErrWrongTokenType
+	}
+	if c.Subject == "" || c.ID == "" || c.IssuedAt == nil {
+		return nil, ErrMissingClaims
+	}
+	return &Principal{
+		UserID:    c.Subject,
+		TokenID:   c.ID,
+		IssuedAt:  c.IssuedAt.Time,
+		ExpiresAt: c.ExpiresAt.Time,
+	}, nil
+}
+
+// StepUp is the proof carried by a verified step-up token.
+type StepUp struct {
+	UserID    string // who passed the OTP
+	Phone     string // where the OTP was sent
+	TokenID   string // revoked once the token is redeemed
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// VerifyStepUp validates a step-up token issued for purpose.
+func (v *Verifier) VerifyStepUp(tok, purpose string) (*StepUp, error) {
+	c, err := v.parse(tok)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != TypeStepUp || c.Purpose != purpose {
+		return nil, ErrWrongTokenType
+	}
+	if c.Subject == "" || c.Phone == "" || c.ID == "" || c.IssuedAt == nil {
+		return nil, ErrMissingClaims
+	}
+	return &StepUp{
+		UserID:    c.Subject,
+		Phone:     c.Phone,
+		TokenID:   c.ID,
+		IssuedAt:  c.IssuedAt.Time,
+		ExpiresAt: c.ExpiresAt.Time,
+	}, nil
+}
+
+func (v *Verifier) parse(tok string) (*Claims, error) {
+	var c Claims
+	if _, err := v.parser.ParseWithClaims(tok, &c, v.keys.Keyfunc); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,233 @@
+package jwtutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "otp-service"
+	testAudience = "api"
+	testLeeway   = 30 * time.Second
+)
+
+func TestVerifier(t *testing.T) {
+	edKey, edPEM := writeEdKey(t, "ed")
+	ed, err := LoadKeySet(edKey, "ed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsKey, rsPEM := writeRSAKey(t)
+	rs, err := LoadKeySet(rsKey, "rs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := writeEdKey(t, "other")
+	other, _ := LoadKeySet(otherKey, "other", nil)
+
+	now := time.Now()
+	claims := func(mut func(*Claims)) Claims {
+		c := Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "jti",
+				Subject:   "user-1",
+				Issuer:    testIssuer,
+				Audience:  jwt.ClaimStrings{testAudience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+			Role: "user",
+		}
+		if mut != nil {
+			mut(&c)
+		}
+		return c
+	}
+	signWith := func(ks *KeySet) func(Claims) string {
+		return func(c Claims) string {
+			tok, err := ks.Sign(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return tok
+		}
+	}
+	// raw signs with any method and key, setting kid like KeySet.Sign
+	raw := func(m jwt.SigningMethod, key any, kid string, c Claims) string {
+		tk := jwt.NewWithClaims(m, c)
+		tk.Header["kid"] = kid
+		s, err := tk.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	signer := &Signer{Keys: ed, Issuer: testIssuer, Audience: testAudience}
+	mfa, _ := signer.MFA("user-1", time.Minute)
+	stepUp, _ := signer.StepUp("user-1", "+989121111111", "delete_account", time.Minute)
+
+	for _, tc := range []struct {
+		name string
+		keys *KeySet
+		tok  string
+		ok   bool
+		is   error // expected error, when it is one of ours
+	}{
+		{"valid EdDSA", ed, signWith(ed)(claims(nil)), true, nil},
+		{"valid RS256", rs, signWith(rs)(claims(nil)), true, nil},
+		{"valid HS256", NewHMACKeySet("s"), signWith(NewHMACKeySet("s"))(claims(nil)), true, nil},
+
+		{"alg none", ed, raw(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "ed", claims(nil)), false, nil},
+		// algorithm confusion: HMAC keyed with the (public) verification key
+		{"HS256 with Ed public key", ed, raw(jwt.SigningMethodHS256, edPEM, "ed", claims(nil)), false, nil},
+		{"HS256 with RSA public key", rs, raw(jwt.SigningMethodHS256, rsPEM, "rs", claims(nil)), false, nil},
+		{"RS256 key, EdDSA token", rs, raw(jwt.SigningMethodEdDSA, other.signing.private, "rs", claims(nil)), false, nil},
+
+		{"unknown kid", ed, signWith(other)(claims(nil)), false, nil},
+		{"known kid, wrong key", ed, raw(jwt.SigningMethodEdDSA, other.signing.private, "ed", claims(nil)), false, nil},
+		{"wrong issuer", ed, signWith(ed)(claims(func(c *Claims) { c.Issuer = "evil" })), false, nil},
+		{"wrong audience", ed, signWith(ed)(claims(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} })), false, nil},
+		{"no expiry", ed, signWith(ed)(claims(func(c *Claims) { c.ExpiresAt = nil })), false, nil},
+		{"expired past leeway", ed, signWith(ed)(claims(func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-testLeeway - 5*time.Second))
+		})), false, nil},
+		{"expired within leeway", ed, signWith(ed)(claims(func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-testLeeway + 5*time.Second))
+		})), true, nil},
+		{"issued in the future", ed, signWith(ed)(claims(func(c *Claims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(testLeeway + time.Minute))
+		})), false, nil},
+		{"missing jti", ed, signWith(ed)(claims(func(c *Claims) { c.ID = "" })), false, ErrMissingClaims},
+
+		{"mfa token as access", ed, mfa, false, ErrWrongTokenType},
+		{"step-up token as access", ed, stepUp, false, ErrWrongTokenType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewVerifier(tc.keys, testIssuer, testAudience, testLeeway).Verify(tc.tok)
+			if tc.ok {
+				if err != nil || p.UserID != "user-1" || p.TokenID != "jti" || p.Role != "user" {
+					t.Fatalf("Verify = %+v, %v", p, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Verify accepted the token: %+v", p)
+			}
+			if tc.is != nil && !errors.Is(err, tc.is) {
+				t.Fatalf("Verify error = %v, want %v", err, tc.is)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenTypes(t *testing.T) {
+	keys := NewHMACKeySet("s")
+	s := &Signer{Keys: keys, Issuer: testIssuer, Audience: testAudience}
+	v := NewVerifier(keys, testIssuer, testAudience, testLeeway)
+	access, _ := s.Access("user-1", "user", time.Minute)
+	mfa, _ := s.MFA("user-1", time.Minute)
+	stepUp, _ := s.StepUp("user-1", "+989121111111", "delete_account", time.Minute)
+
+	if p, err := v.VerifyMFA(mfa); err != nil || p.UserID != "user-1" || p.TokenID == "" || p.IssuedAt.IsZero() {
+		t.Errorf("VerifyMFA(mfa) = %+v, %v", p, err)
+	}
+	for name, tok := range map[string]string{"access": access, "step-up": stepUp} {
+		if _, err := v.VerifyMFA(tok); !errors.Is(err, ErrWrongTokenType) {
+			t.Errorf("VerifyMFA(%s) = %v, want ErrWrongTokenType", name, err)
+		}
+	}
+
+	if p, err := v.VerifyStepUp(stepUp, "delete_account"); err != nil || p.UserID != "user-1" || p.Phone != "+989121111111" || p.TokenID == "" {
+		t.Errorf("VerifyStepUp = %+v, %v", p, err)
+	}
+	// tokens from before step-ups were bound to a user carried the phone as subject
+	legacy, _ := s.Keys.Sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Subject:   "+989121111111",
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Type:    TypeStepUp,
+		Purpose: "delete_account",
+	})
+	if _, err := v.VerifyStepUp(legacy, "delete_account"); !errors.Is(err, ErrMissingClaims) {
+		t.Errorf("VerifyStepUp(unbound) = %v, want ErrMissingClaims", err)
+	}
+	if _, err := v.VerifyStepUp(stepUp, "change_phone"); !errors.Is(err, ErrWrongTokenType) {
+		t.Errorf("VerifyStepUp(other purpose) = %v, want ErrWrongTokenType", err)
+	}
+	for name, tok := range map[string]string{"access": access, "mfa": mfa} {
+		if _, err := v.VerifyStepUp(tok, ""); !errors.Is(err, ErrWrongTokenType) {
+			t.Errorf("VerifyStepUp(%s) = %v, want ErrWrongTokenType", name, err)
+		}
+	}
+}
+
+// writeEdKey writes a new Ed25519 private key as PEM and returns its path and the
+// PEM of its public key.
+func writeEdKey(t *testing.T, name string) (string, []byte) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeKeyPair(t, name, priv, pub)
+}
+
+func writeRSAKey(t *testing.T) (string, []byte) {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeKeyPair(t, "rsa", priv, &priv.PublicKey)
+}
+
+func writeKeyPair(t *testing.T, name string, priv, pub any) (string, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name+".pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+}
+
+func TestVerifyScopes(t *testing.T) {
+	keys := NewHMACKeySet("s")
+	s := &Signer{Keys: keys, Issuer: testIssuer, Audience: testAudience}
+	v := NewVerifier(keys, testIssuer, testAudience, testLeeway)
+
+	tok, _ := s.Access("user-1", "user", time.Minute, "otp:read", "users:write")
+	p, err := v.Verify(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasScope("otp:read") || !p.HasScope("users:write") || p.HasScope("admin") {
+		t.Fatalf("scopes = %v", p.Scopes)
+	}
+	tok, _ = s.Access("user-1", "user", time.Minute)
+	if p, err := v.Verify(tok); err != nil || len(p.Scopes) != 0 || p.HasScope("otp:read") {
+		t.Fatalf("unscoped token = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,29 @@
+package otp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTooManyAttempts is returned by Validate once a phone has used up its wrong guesses;
+// the pending code is invalidated and a new one has to be requested.
+var ErrTooManyAttempts = errors.New("otp: too many attempts")
+
+// ErrCapacity is returned by the in-memory manager and limiter when a new key would
+// exceed their cap and nothing has expired: live state is never evicted to make room.
+var ErrCapacity = errors.New("otp: in-memory store full")
+
+// ErrCooldown matches (errors.Is) every *CooldownError.
+var ErrCooldown = errors.New("otp: resend cooldown")
+
+// CooldownError is returned by Generate when the last code was sent too recently.
+type CooldownError struct {
+	Wait time.Duration // until a new code may be requested
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("otp: resend cooldown, retry in %v", e.Wait)
+}
+
+func (e *CooldownError) Is(target error) bool { return target == ErrCooldown }
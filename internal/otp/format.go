@@ -0,0 +1,109 @@
+package otp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Code alphabets
+const (
+	AlphabetNumeric = "0123456789"
+	// AlphabetAlphanumeric leaves out characters that are easy to misread: 0/O, 1/I/L.
+	AlphabetAlphanumeric = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+)
+
+// Code length bounds
+const (
+	MinCodeLength     = 4
+	MaxCodeLength     = 10
+	DefaultCodeLength = 6
+)
+
+// Format describes generated codes. The zero value is 6 decimal digits.
+type Format struct {
+	Length   int
+	Alphabet string
+}
+
+// NewFormat builds a Format from config: alphabet is "numeric" or "alphanumeric".
+func NewFormat(length int, alphabet string) (Format, error) {
+	if length < MinCodeLength || length > MaxCodeLength {
+		return Format{}, fmt.Errorf("otp length must be %d-%d, got %d", MinCodeLength, MaxCodeLength, length)
+	}
+	switch alphabet {
+	case "numeric", "":
+		return Format{Length: length, Alphabet: AlphabetNumeric}, nil
+	case "alphanumeric":
+		return Format{Length: length, Alphabet: AlphabetAlphanumeric}, nil
+	}
+	return Format{}, fmt.Errorf("otp alphabet must be numeric or alphanumeric, got %q", alphabet)
+}
+
+func (f Format) length() int {
+	if f.Length == 0 {
+		return DefaultCodeLength
+	}
+	return f.Length
+}
+
+func (f Format) alphabet() string {
+	if f.Alphabet == "" {
+		return AlphabetNumeric
+	}
+	return f.Alphabet
+}
+
+// Generate returns a random code. Bytes that would bias the modulo are rejected
+// and redrawn, so every character of the alphabet is equally likely.
+func (f Format) Generate() (string, error) {
+	alphabet, n := f.alphabet(), f.length()
+	limit := 256 - 256%len(alphabet) // largest multiple of len(alphabet) that fits a byte
+	out := make([]byte, 0, n)
+	buf := make([]byte, n*2)
+	for len(out) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			out = append(out, alphabet[int(b)%len(alphabet)])
+			if len(out) == n {
+				break
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// Canonical folds user input to the alphabet's case (alphanumeric codes are upper case).
+func (f Format) Canonical(code string) string {
+	if f.alphabet() == AlphabetNumeric {
+		return code
+	}
+	return strings.ToUpper(code)
+}
+
+// Valid reports whether code (already Canonical) has the format's length and alphabet.
+func (f Format) Valid(code string) bool {
+	if len(code) != f.length() {
+		return false
+	}
+	alphabet := f.alphabet()
+	for i := 0; i < len(code); i++ {
+		if strings.IndexByte(alphabet, code[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the format for error messages, e.g. "6 digits".
+func (f Format) String() string {
+	if f.alphabet() == AlphabetNumeric {
+		return fmt.Sprintf("%d digits", f.length())
+	}
+	return fmt.Sprintf("%d letters or digits", f.length())
+}
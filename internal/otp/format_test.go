@@ -0,0 +1,82 @@
+package otp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+func TestNewFormat(t *testing.T) {
+	for _, tc := range []struct {
+		length   int
+		alphabet string
+		ok       bool
+	}{
+		{6, "numeric", true},
+		{4, "alphanumeric", true},
+		{10, "", true},
+		{3, "numeric", false},
+		{11, "numeric", false},
+		{6, "hex", false},
+	} {
+		if _, err := otp.NewFormat(tc.length, tc.alphabet); (err == nil) != tc.ok {
+			t.Errorf("NewFormat(%d, %q) = %v", tc.length, tc.alphabet, err)
+		}
+	}
+}
+
+func TestFormatGenerate(t *testing.T) {
+	for _, f := range []otp.Format{
+		{},
+		{Length: 4, Alphabet: otp.AlphabetNumeric},
+		{Length: 10, Alphabet: otp.AlphabetAlphanumeric},
+	} {
+		seen := map[byte]int{}
+		for range 2000 {
+			code, err := f.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.Valid(code) {
+				t.Fatalf("%s: generated %q does not validate", f, code)
+			}
+			for i := 0; i < len(code); i++ {
+				seen[code[i]]++
+			}
+		}
+		alphabet := f.Alphabet
+		if alphabet == "" {
+			alphabet = otp.AlphabetNumeric
+		}
+		if len(seen) != len(alphabet) {
+			t.Errorf("%s: %d distinct characters, want %d", f, len(seen), len(alphabet))
+		}
+	}
+}
+
+func TestFormatValid(t *testing.T) {
+	num := otp.Format{}
+	alnum := otp.Format{Length: 6, Alphabet: otp.AlphabetAlphanumeric}
+	for _, tc := range []struct {
+		f    otp.Format
+		code string
+		want bool
+	}{
+		{num, "123456", true},
+		{num, "12345", false},
+		{num, "1234567", false},
+		{num, "12345a", false},
+		{alnum, "AB23CD", true},
+		{alnum, alnum.Canonical("ab23cd"), true},
+		{alnum, "AB23C0", false}, // 0 is left out of the alphabet
+		{alnum, "ab23cd", false},
+	} {
+		if got := tc.f.Valid(tc.code); got != tc.want {
+			t.Errorf("%s: Valid(%q) = %v, want %v", tc.f, tc.code, got, tc.want)
+		}
+	}
+	if strings.ContainsAny(otp.AlphabetAlphanumeric, "01ILO") {
+		t.Error("alphanumeric alphabet has ambiguous characters")
+	}
+}
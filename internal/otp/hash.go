@@ -0,0 +1,55 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hasher keys codes with a server secret (HMAC-SHA256) so stores never hold plaintext.
+// Previous secrets keep validating codes issued before a secret rotation.
+type Hasher struct {
+	current  []byte
+	previous [][]byte
+}
+
+func NewHasher(secret string, previous []string) *Hasher {
+	h := &Hasher{current: []byte(secret)}
+	for _, p := range previous {
+		h.previous = append(h.previous, []byte(p))
+	}
+	return h
+}
+
+// Hash returns the value to store for a code issued to phone.
+func (h *Hasher) Hash(phone, code string) string {
+	return sum(h.current, phone, code)
+}
+
+// Match reports whether code is the one behind stored, comparing in constant time.
+func (h *Hasher) Match(phone, code, stored string) bool {
+	ok := hmac.Equal([]byte(stored), []byte(sum(h.current, phone, code)))
+	for _, p := range h.previous {
+		// no early exit: every secret is always tried
+		ok = hmac.Equal([]byte(stored), []byte(sum(p, phone, code))) || ok
+	}
+	return ok
+}
+
+// Candidates returns every stored value code would match (current secret first, then
+// previous ones). Used where the comparison has to happen inside the store, e.g. Redis Lua.
+func (h *Hasher) Candidates(phone, code string) []string {
+	out := []string{sum(h.current, phone, code)}
+	for _, p := range h.previous {
+		out = append(out, sum(p, phone, code))
+	}
+	return out
+}
+
+func sum(secret []byte, phone, code string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(phone))
+	mac.Write([]byte{':'})
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,94 @@
+package memoryotp
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// JanitorOptions bound the memory held by the in-memory manager and limiter.
+// Without them, entries of phones that never come back are only dropped on restart.
+type JanitorOptions struct {
+	Interval time.Duration // how often expired entries are swept; <= 0 disables the sweeper
+	MaxKeys  int           // hard cap on keys per map; at the cap new keys fail with otp.ErrCapacity. <= 0 is unbounded
+}
+
+// stats counts swept ("*_expired") entries and keys refused at the cap ("*_refused"),
+// published through expvar as "otp_memory".
+var stats = expvar.NewMap("otp_memory")
+
+// janitor calls sweep every interval until Close or ctx is done.
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startJanitor(ctx context.Context, interval time.Duration, sweep func()) *janitor {
+	j := &janitor{stop: make(chan struct{}), done: make(chan struct{})}
+	if interval <= 0 {
+		close(j.done)
+		return j
+	}
+	go func() {
+		defer close(j.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				sweep()
+				stats.Add("sweeps", 1)
+			case <-j.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Close stops the sweeper and waits for a running sweep to finish. It is safe to call twice.
+func (j *janitor) Close() error {
+	j.once.Do(func() { close(j.stop) })
+	<-j.done
+	return nil
+}
+
+// capScanInterval spaces out the scans for expired entries at the key cap, so a
+// flood of new keys cannot turn every insert into a walk over the whole map.
+const capScanInterval = time.Second
+
+// capped bounds a map to maxKeys (<= 0 is unbounded).
+type capped struct {
+	maxKeys  int
+	lastScan time.Time
+}
+
+// makeRoom reports whether key may be stored in m. Existing keys and maps under the cap
+// always may. At the cap only expired entries are dropped, by a scan at most every
+// capScanInterval; live ones are never evicted and the new key is refused instead
+// (counted as stat). Callers hold the map's lock.
+func makeRoom[V any](c *capped, m map[string]V, key string, now time.Time, expired func(V) bool, stat string) bool {
+	if c.maxKeys <= 0 || len(m) < c.maxKeys {
+		return true
+	}
+	if _, ok := m[key]; ok {
+		return true
+	}
+	if now.Sub(c.lastScan) >= capScanInterval {
+		c.lastScan = now
+		for k, v := range m {
+			if expired(v) {
+				delete(m, k)
+			}
+		}
+	}
+	if len(m) < c.maxKeys {
+		return true
+	}
+	stats.Add(stat, 1)
+	return false
+}
@@ -0,0 +1,197 @@
+package memoryotp
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+func statValue(name string) int64 {
+	if v, ok := stats.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func testOptions() otp.Options {
+	return otp.Options{
+		TTL:             time.Minute,
+		MaxAttempts:     3,
+		Hasher:          otp.NewHasher("secret", nil),
+		Sender:          nopSender{},
+		ResendCooldowns: []time.Duration{30 * time.Second},
+		ResendReset:     time.Hour,
+	}
+}
+
+func TestManagerSweep(t *testing.T) {
+	m := NewManager(t.Context(), testOptions(), JanitorOptions{}).(*manager)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	for i := range 5 {
+		if _, err := m.Generate(t.Context(), otp.PurposeLogin, fmt.Sprintf("+98912000000%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired, sends := statValue("otp_expired"), statValue("sends_expired")
+
+	now = now.Add(2 * time.Minute) // codes expired, cooldown sequences still running
+	m.sweep()
+	if len(m.m) != 0 || len(m.sends) != 5 {
+		t.Fatalf("after TTL: %d codes, %d sends; want 0, 5", len(m.m), len(m.sends))
+	}
+	now = now.Add(time.Hour)
+	m.sweep()
+	if len(m.sends) != 0 {
+		t.Fatalf("after reset: %d sends; want 0", len(m.sends))
+	}
+	if d := statValue("otp_expired") - expired; d != 5 {
+		t.Errorf("otp_expired grew by %d, want 5", d)
+	}
+	if d := statValue("sends_expired") - sends; d != 5 {
+		t.Errorf("sends_expired grew by %d, want 5", d)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := NewLimiter(t.Context(), JanitorOptions{}).(*limiter)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	short, long := otp.Policy{Limit: 3, Window: time.Minute}, otp.Policy{Limit: 3, Window: time.Hour}
+	for i := range 4 {
+		if _, err := l.Allow(t.Context(), fmt.Sprintf("short:%d", i), short); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Allow(t.Context(), "long", long); err != nil {
+		t.Fatal(err)
+	}
+	expired := statValue("limiter_expired")
+
+	now = now.Add(time.Minute)
+	l.sweep()
+	if _, ok := l.records["long"]; len(l.records) != 1 || !ok {
+		t.Fatalf("records after sweep: %v; want only the long window key", l.records)
+	}
+	if d := statValue("limiter_expired") - expired; d != 4 {
+		t.Errorf("limiter_expired grew by %d, want 4", d)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	j := JanitorOptions{MaxKeys: 3}
+	m := NewManager(t.Context(), testOptions(), j).(*manager)
+	l := NewLimiter(t.Context(), j).(*limiter)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	l.now = func() time.Time { return now }
+	p := otp.Policy{Limit: 2, Window: time.Minute}
+	phone := func(i int) string { return fmt.Sprintf("+98912000000%d", i) }
+
+	for i := range 3 {
+		if _, err := m.Generate(t.Context(), otp.PurposeLogin, phone(i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := l.Allow(t.Context(), phone(i), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refusals := func() int64 {
+		return statValue("otp_refused") + statValue("sends_refused") + statValue("limiter_refused")
+	}
+	refused := refusals()
+
+	// at the cap with nothing expired a new key is refused, live state is kept
+	if _, err := m.Generate(t.Context(), otp.PurposeLogin, phone(3)); !errors.Is(err, otp.ErrCapacity) {
+		t.Fatalf("Generate of a 4th phone: %v, want ErrCapacity", err)
+	}
+	if _, err := l.Allow(t.Context(), phone(3), p); !errors.Is(err, otp.ErrCapacity) {
+		t.Fatalf("Allow of a 4th key: %v, want ErrCapacity", err)
+	}
+	if d := refusals() - refused; d != 2 {
+		t.Errorf("refusals grew by %d, want 2", d)
+	}
+	for i := range 3 {
+		if _, ok := m.m[slot(otp.PurposeLogin, phone(i))]; !ok {
+			t.Fatalf("code of %s evicted", phone(i))
+		}
+		if wait, _ := m.Cooldown(t.Context(), otp.PurposeLogin, phone(i)); wait == 0 {
+			t.Fatalf("cooldown of %s reset", phone(i))
+		}
+	}
+
+	// known keys keep counting: the cap never resets a budget
+	if res, err := l.Allow(t.Context(), phone(0), p); err != nil || !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Allow of a known key = %+v, %v", res, err)
+	}
+	if res, err := l.Allow(t.Context(), phone(0), p); err != nil || res.Allowed {
+		t.Fatalf("Allow over budget = %+v, %v; want denied", res, err)
+	}
+
+	// once entries expire they make room, even before the sweeper runs
+	now = now.Add(2 * time.Hour)
+	if _, err := m.Generate(t.Context(), otp.PurposeLogin, phone(3)); err != nil {
+		t.Fatalf("Generate after expiry: %v", err)
+	}
+	if _, err := l.Allow(t.Context(), phone(3), p); err != nil {
+		t.Fatalf("Allow after expiry: %v", err)
+	}
+	if len(m.m) > 3 || len(m.sends) > 3 || len(l.records) > 3 {
+		t.Fatalf("%d codes, %d sends, %d records; cap is 3", len(m.m), len(m.sends), len(l.records))
+	}
+}
+
+func TestJanitorRunsUntilClosed(t *testing.T) {
+	m := NewManager(t.Context(), testOptions(), JanitorOptions{Interval: time.Millisecond}).(*manager)
+	if _, err := m.Generate(t.Context(), otp.PurposeLogin, "+989121234567"); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	m.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		n := len(m.m) + len(m.sends)
+		m.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not sweep expired entries")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal("second Close:", err)
+	}
+	select {
+	case <-m.done:
+	default:
+		t.Fatal("sweeper still running after Close")
+	}
+}
+
+func TestJanitorStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	l := NewLimiter(ctx, JanitorOptions{Interval: time.Millisecond}).(*limiter)
+	cancel()
+	select {
+	case <-l.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweeper still running after ctx was cancelled")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,37 +8,105 @@ import (
 	"github.com/TheAmirMohammad/otp-service/internal/otp"
 )
 
+// limiter is a sliding log: the times of allowed events per key within the window.
 type limiter struct {
 	mu      sync.Mutex
-	window  time.Duration
-	limit   int
-	records map[string][]time.Time
+	cap     capped
+	records map[string]events
+	now     func() time.Time
+	*janitor
 }
 
-func NewLimiter(limit int, window time.Duration) otp.Limiter {
-	return &limiter{
-		window:  window,
-		limit:   limit,
-		records: make(map[string][]time.Time),
+type events struct {
+	Times []time.Time
+	Until time.Time // the last event leaves the window, the key can go
+}
+
+// NewLimiter keeps counters in process memory; its janitor runs until Close or ctx is done.
+func NewLimiter(ctx context.Context, j JanitorOptions) otp.Limiter {
+	l := &limiter{
+		cap:     capped{maxKeys: j.MaxKeys},
+		records: make(map[string]events),
+		now:     time.Now,
 	}
+	l.janitor = startJanitor(ctx, j.Interval, l.sweep)
+	return l
 }
 
-func (l *limiter) Allow(_ context.Context, phone string) (bool, error) {
+func (l *limiter) Allow(_ context.Context, key string, p otp.Policy) (otp.Result, error) {
+	if p.Limit <= 0 {
+		return otp.Result{Allowed: true}, nil
+	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	now := time.Now()
-	cut := now.Add(-l.window)
-	arr := l.records[phone][:0]
-	for _, t := range l.records[phone] {
+	now := l.now()
+	cut := now.Add(-p.Window)
+	arr := l.records[key].Times[:0]
+	for _, t := range l.records[key].Times {
 		if t.After(cut) {
 			arr = append(arr, t)
 		}
 	}
-	if len(arr) >= l.limit {
-		l.records[phone] = arr
-		return false, nil
+	res := otp.Result{Limit: p.Limit}
+	if len(arr) < p.Limit {
+		arr = append(arr, now)
+		res.Allowed = true
+	}
+	if !makeRoom(&l.cap, l.records, key, now, l.expired(now), "limiter_refused") {
+		return otp.Result{}, otp.ErrCapacity
+	}
+	l.records[key] = events{Times: arr, Until: arr[len(arr)-1].Add(p.Window)}
+	res.Remaining = p.Limit - len(arr)
+	res.ResetAt = arr[0].Add(p.Window)
+	return res, nil
+}
+
+func (l *limiter) Peek(_ context.Context, key string, p otp.Policy) (otp.Result, error) {
+	if p.Limit <= 0 {
+		return otp.Result{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	cut := now.Add(-p.Window)
+	res := otp.Result{Limit: p.Limit, ResetAt: now}
+	n := 0
+	for _, t := range l.records[key].Times {
+		if t.After(cut) {
+			if n == 0 {
+				res.ResetAt = t.Add(p.Window)
+			}
+			n++
+		}
+	}
+	res.Allowed = n < p.Limit
+	res.Remaining = max(p.Limit-n, 0)
+	return res, nil
+}
+
+func (l *limiter) Reset(_ context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, key)
+	return nil
+}
+
+// expired reports the records without events left in their window at now.
+func (l *limiter) expired(now time.Time) func(events) bool {
+	return func(ev events) bool { return !now.Before(ev.Until) }
+}
+
+// sweep drops keys without events left in their window.
+func (l *limiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, ev := range l.records {
+		if !now.Before(ev.Until) {
+			delete(l.records, key)
+			stats.Add("limiter_expired", 1)
+		}
 	}
-	l.records[phone] = append(arr, now)
-	return true, nil
 }
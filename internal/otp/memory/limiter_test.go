@@ -0,0 +1,18 @@
+package memoryotp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/otp/otptest"
+)
+
+func TestLimiter(t *testing.T) {
+	otptest.TestLimiter(t, func(t *testing.T, start time.Time) (otp.Limiter, func(time.Time)) {
+		l := NewLimiter(t.Context(), JanitorOptions{}).(*limiter)
+		now := start
+		l.now = func() time.Time { return now }
+		return l, func(t time.Time) { now = t }
+	})
+}
@@ -2,9 +2,7 @@ package memoryotp
 
 import (
 	"context"
-	"crypto/rand"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 
@@ -12,50 +10,186 @@ import (
 )
 
 type manager struct {
-	mu  sync.RWMutex
-	ttl time.Duration
-	m   map[string]record
+	mu       sync.Mutex
+	opts     otp.Options
+	m        map[string]record    // by slot(purpose, phone)
+	sends    map[string]sendState // by slot(purpose, phone)
+	mCap     capped
+	sendsCap capped
+	now      func() time.Time
+	*janitor
 }
 
 type record struct {
-	Code      string
+	Hash      string // HMAC of the code, see otp.Hasher
 	ExpiresAt time.Time
+	Attempts  int // wrong guesses so far
 }
 
-func NewManager(ttl time.Duration) otp.Service {
-	return &manager{ttl: ttl, m: make(map[string]record)}
+// sendState drives the resend cooldown of a phone.
+type sendState struct {
+	Count     int       // sends in a row
+	Next      time.Time // no new code before this
+	ExpiresAt time.Time // the sequence restarts after this
 }
 
-func (m *manager) Generate(_ context.Context, phone string) (string, error) {
-	code, err := genCode()
+// NewManager keeps codes in process memory; its janitor runs until Close or ctx is done.
+func NewManager(ctx context.Context, opts otp.Options, j JanitorOptions) otp.Service {
+	m := &manager{
+		opts:     opts,
+		m:        make(map[string]record),
+		sends:    make(map[string]sendState),
+		mCap:     capped{maxKeys: j.MaxKeys},
+		sendsCap: capped{maxKeys: j.MaxKeys},
+		now:      time.Now,
+	}
+	m.janitor = startJanitor(ctx, j.Interval, m.sweep)
+	return m
+}
+
+func (m *manager) Generate(ctx context.Context, purpose, phone string) (otp.Issued, error) {
+	code, test, err := m.opts.TestCode(ctx, phone)
 	if err != nil {
-		return "", err
+		return otp.Issued{}, err
 	}
+	if !test {
+		if code, err = m.opts.Format.Generate(); err != nil {
+			return otp.Issued{}, err
+		}
+	}
+	key, ttl := slot(purpose, phone), m.opts.TTLFor(purpose)
 	m.mu.Lock()
-	m.m[phone] = record{Code: code, ExpiresAt: time.Now().Add(m.ttl)}
+	now := m.now()
+	st := m.sendState(key, now)
+	if wait := st.Next.Sub(now); wait > 0 {
+		m.mu.Unlock()
+		return otp.Issued{}, &otp.CooldownError{Wait: wait}
+	}
+	prev := st
+	st.Count++
+	cd := m.opts.Cooldown(st.Count)
+	st.Next, st.ExpiresAt = now.Add(cd), now.Add(m.opts.ResendKeep(cd))
+	if !makeRoom(&m.sendsCap, m.sends, key, now, func(s sendState) bool { return now.After(s.ExpiresAt) }, "sends_refused") ||
+		!makeRoom(&m.mCap, m.m, key, now, func(r record) bool { return now.After(r.ExpiresAt) }, "otp_refused") {
+		m.mu.Unlock()
+		return otp.Issued{}, otp.ErrCapacity
+	}
+	hash := m.opts.Hasher.Hash(phone, code)
+	m.sends[key] = st
+	m.m[key] = record{Hash: hash, ExpiresAt: now.Add(ttl)}
 	m.mu.Unlock()
-	log.Printf("[OTP] phone=%s code=%s (expires in %s)", phone, code, m.ttl)
-	return code, nil
+	if !test { // test numbers are never delivered
+		if err := m.opts.Sender.Send(ctx, phone, code, purpose, ttl); err != nil {
+			m.unsend(key, hash, prev)
+			return otp.Issued{}, fmt.Errorf("send otp: %w", err)
+		}
+	}
+	return otp.Issued{Code: code, ExpiresIn: ttl, ResendIn: cd}, nil
 }
 
-func (m *manager) Validate(_ context.Context, phone, code string) (bool, error) {
-	m.mu.RLock()
-	rec, ok := m.m[phone]
-	m.mu.RUnlock()
-	if !ok || time.Now().After(rec.ExpiresAt) || rec.Code != code {
-		return false, nil
+// unsend undoes a Generate whose code was never delivered: the code is dropped and the
+// cooldown goes back to prev, so the phone can ask again at once. A newer code issued
+// meanwhile is left alone.
+func (m *manager) unsend(key, hash string, prev sendState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec, ok := m.m[key]; !ok || rec.Hash != hash {
+		return
 	}
+	delete(m.m, key)
+	if prev.Count == 0 {
+		delete(m.sends, key)
+	} else {
+		m.sends[key] = prev
+	}
+}
+
+func (m *manager) Cooldown(_ context.Context, purpose, phone string) (time.Duration, error) {
 	m.mu.Lock()
-	delete(m.m, phone) // one-time use
-	m.mu.Unlock()
+	defer m.mu.Unlock()
+	now := m.now()
+	return max(m.sendState(slot(purpose, phone), now).Next.Sub(now), 0), nil
+}
+
+// sendState returns the live cooldown state of a slot; callers hold m.mu.
+func (m *manager) sendState(key string, now time.Time) sendState {
+	st, ok := m.sends[key]
+	if ok && now.After(st.ExpiresAt) {
+		delete(m.sends, key)
+		return sendState{}
+	}
+	return st
+}
+
+func (m *manager) Validate(_ context.Context, purpose, phone, code string) (bool, error) {
+	key := slot(purpose, phone)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.m[key]
+	if !ok || m.now().After(rec.ExpiresAt) {
+		delete(m.m, key)
+		return false, nil
+	}
+	// locked records stay until they expire so further guesses keep failing
+	if rec.Attempts >= m.opts.MaxAttempts {
+		return false, otp.ErrTooManyAttempts
+	}
+	if !m.opts.Hasher.Match(phone, code, rec.Hash) {
+		rec.Attempts++
+		m.m[key] = rec
+		if rec.Attempts >= m.opts.MaxAttempts {
+			return false, otp.ErrTooManyAttempts
+		}
+		return false, nil
+	}
+	delete(m.m, key) // one-time use
+	delete(m.sends, key)
 	return true, nil
 }
 
-func genCode() (string, error) {
-	var b [3]byte
-	if _, err := rand.Read(b[:]); err != nil {
-		return "", err
+func (m *manager) Pending(_ context.Context, purpose, phone string) (*otp.Pending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.m[slot(purpose, phone)]
+	now := m.now()
+	if !ok || now.After(rec.ExpiresAt) {
+		return nil, nil
 	}
-	n := (int(b[0])<<16 | int(b[1])<<8 | int(b[2])) % 1000000
-	return fmt.Sprintf("%06d", n), nil
+	return &otp.Pending{
+		ExpiresIn: rec.ExpiresAt.Sub(now),
+		Attempts:  rec.Attempts,
+		Locked:    rec.Attempts >= m.opts.MaxAttempts,
+	}, nil
 }
+
+func (m *manager) Cancel(_ context.Context, purpose, phone string) (bool, error) {
+	key := slot(purpose, phone)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec, ok := m.m[key]
+	delete(m.m, key)
+	delete(m.sends, key)
+	return ok && !m.now().After(rec.ExpiresAt), nil
+}
+
+// sweep drops expired codes and finished cooldown sequences.
+func (m *manager) sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for key, rec := range m.m {
+		if now.After(rec.ExpiresAt) {
+			delete(m.m, key)
+			stats.Add("otp_expired", 1)
+		}
+	}
+	for key, st := range m.sends {
+		if now.After(st.ExpiresAt) {
+			delete(m.sends, key)
+			stats.Add("sends_expired", 1)
+		}
+	}
+}
+
+// slot keys the maps: codes and cooldowns are kept per purpose and phone.
+func slot(purpose, phone string) string { return purpose + ":" + phone }
@@ -0,0 +1,132 @@
+package memoryotp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/otp/otptest"
+)
+
+type nopSender struct{}
+
+func (nopSender) Send(context.Context, string, string, string, time.Duration) error { return nil }
+
+func TestValidateConcurrentSingleWinner(t *testing.T) {
+	m := NewManager(t.Context(), otp.Options{
+		TTL:         time.Minute,
+		MaxAttempts: 3,
+		Hasher:      otp.NewHasher("secret", nil),
+		Sender:      nopSender{},
+	}, JanitorOptions{})
+	ctx := context.Background()
+	iss, err := m.Generate(ctx, otp.PurposeLogin, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 50
+	var (
+		wg   sync.WaitGroup
+		wins atomic.Int32
+	)
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if ok, _ := m.Validate(ctx, otp.PurposeLogin, "+989121234567", iss.Code); ok {
+				wins.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := wins.Load(); got != 1 {
+		t.Fatalf("expected exactly 1 successful validation, got %d", got)
+	}
+}
+
+func TestValidateLocksAfterMaxAttempts(t *testing.T) {
+	m, advance := newTestService(t, otp.Options{
+		TTL:         time.Minute,
+		MaxAttempts: 3,
+		Hasher:      otp.NewHasher("secret", nil),
+		Sender:      nopSender{},
+	}, time.Now())
+	ctx := context.Background()
+	const phone = "+989121234567"
+	iss, err := m.Generate(ctx, otp.PurposeLogin, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if iss.Code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 1; i <= 3; i++ {
+		ok, err := m.Validate(ctx, otp.PurposeLogin, phone, wrong)
+		if ok {
+			t.Fatal("wrong code accepted")
+		}
+		if i < 3 && err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i, err)
+		}
+		if i == 3 && err != otp.ErrTooManyAttempts {
+			t.Fatalf("attempt %d: expected ErrTooManyAttempts, got %v", i, err)
+		}
+	}
+	// the right code no longer helps, and the record stays locked until it expires
+	if ok, err := m.Validate(ctx, otp.PurposeLogin, phone, iss.Code); ok || err != otp.ErrTooManyAttempts {
+		t.Fatalf("locked code: got ok=%v err=%v", ok, err)
+	}
+	if p, err := m.Pending(ctx, otp.PurposeLogin, phone); err != nil || p == nil || !p.Locked || p.Attempts != 3 {
+		t.Fatalf("Pending(locked) = %+v, %v", p, err)
+	}
+	advance(time.Now().Add(time.Minute + time.Second))
+	if ok, err := m.Validate(ctx, otp.PurposeLogin, phone, iss.Code); ok || err != nil {
+		t.Fatalf("expired locked code: got ok=%v err=%v", ok, err)
+	}
+
+	// a new code starts with a clean count
+	iss, err = m.Generate(ctx, otp.PurposeLogin, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.Validate(ctx, otp.PurposeLogin, phone, iss.Code); !ok || err != nil {
+		t.Fatalf("new code: got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestResendCooldown(t *testing.T) {
+	otptest.TestResendCooldown(t, newTestService)
+}
+
+func TestPendingAndCancel(t *testing.T) {
+	otptest.TestPendingAndCancel(t, newTestService)
+}
+
+func TestTestNumbers(t *testing.T) {
+	otptest.TestTestNumbers(t, newTestService)
+}
+
+func TestPurposes(t *testing.T) {
+	otptest.TestPurposes(t, newTestService)
+}
+
+func TestSendFailure(t *testing.T) {
+	otptest.TestSendFailure(t, newTestService)
+}
+
+func newTestService(t *testing.T, opts otp.Options, start time.Time) (otp.Service, func(time.Time)) {
+	m := NewManager(t.Context(), opts, JanitorOptions{}).(*manager)
+	now := start
+	m.now = func() time.Time { return now }
+	return m, func(t time.Time) { now = t }
+}
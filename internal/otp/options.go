@@ -0,0 +1,58 @@
+package otp
+
+import (
+	"context"
+	"time"
+)
+
+// Options shared by the memory and redis managers.
+type Options struct {
+	TTL         time.Duration // how long a code stays valid (see PurposeTTLs)
+	Format      Format        // length and alphabet of generated codes
+	MaxAttempts int           // wrong guesses before the code is locked
+	Hasher      *Hasher       // codes are stored as HMACs, never plaintext
+	Sender      Sender        // delivery channel
+	TestNumbers TestNumbers   // phones with a fixed, undelivered code (nil: none)
+
+	// PurposeTTLs overrides TTL for some purposes, e.g. a longer one for change_phone.
+	PurposeTTLs map[string]time.Duration
+
+	// ResendCooldowns is the minimum wait after the 1st, 2nd, ... send to a phone; the
+	// last entry repeats. Empty disables the cooldown. The sequence restarts after a
+	// successful Validate or ResendReset without sends.
+	ResendCooldowns []time.Duration
+	ResendReset     time.Duration
+
+	// AcceptLegacy keeps plaintext codes written by a pre-hashing deployment valid
+	// (they are re-hashed in place on first use). Only needed for one OTP TTL after rollout.
+	AcceptLegacy bool
+}
+
+// TTLFor returns how long a code issued for purpose stays valid.
+func (o Options) TTLFor(purpose string) time.Duration {
+	if ttl, ok := o.PurposeTTLs[purpose]; ok {
+		return ttl
+	}
+	return o.TTL
+}
+
+// Cooldown returns the wait imposed after the n-th (1-based) send in a row.
+func (o Options) Cooldown(n int) time.Duration {
+	if len(o.ResendCooldowns) == 0 || n < 1 {
+		return 0
+	}
+	return o.ResendCooldowns[min(n, len(o.ResendCooldowns))-1]
+}
+
+// ResendKeep is how long the send counter must be kept after a send with cooldown cd.
+func (o Options) ResendKeep(cd time.Duration) time.Duration {
+	return max(o.ResendReset, cd)
+}
+
+// TestCode returns the fixed code of a test number (ok false for regular phones).
+func (o Options) TestCode(ctx context.Context, phone string) (string, bool, error) {
+	if o.TestNumbers == nil {
+		return "", false, nil
+	}
+	return o.TestNumbers.TestCode(ctx, phone)
+}
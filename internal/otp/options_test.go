@@ -0,0 +1,21 @@
+package otp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+func TestOptionsTTLFor(t *testing.T) {
+	o := otp.Options{
+		TTL:         2 * time.Minute,
+		PurposeTTLs: map[string]time.Duration{otp.PurposeChangePhone: 10 * time.Minute},
+	}
+	if got := o.TTLFor(otp.PurposeLogin); got != 2*time.Minute {
+		t.Errorf("TTLFor(login) = %v, want the default TTL", got)
+	}
+	if got := o.TTLFor(otp.PurposeChangePhone); got != 10*time.Minute {
+		t.Errorf("TTLFor(change_phone) = %v, want the override", got)
+	}
+}
@@ -0,0 +1,178 @@
+// Package otptest holds behavioural test suites shared by the otp backends.
+package otptest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// NewLimiterFunc returns a fresh limiter whose clock reads start, and a function
+// moving that clock forward to a later time.
+type NewLimiterFunc func(t *testing.T, start time.Time) (l otp.Limiter, setNow func(time.Time))
+
+// TestLimiter checks the sliding-window semantics every otp.Limiter must have.
+func TestLimiter(t *testing.T, newLimiter NewLimiterFunc) {
+	ctx := context.Background()
+	policy := otp.Policy{Limit: 3, Window: 10 * time.Minute}
+
+	// whole milliseconds: the Redis limiter scores events in ms
+	start := time.Now().Truncate(time.Millisecond)
+	setup := func(t *testing.T) (otp.Limiter, func(time.Duration)) {
+		l, setNow := newLimiter(t, start)
+		now := start
+		return l, func(d time.Duration) {
+			now = now.Add(d)
+			setNow(now)
+		}
+	}
+	allow := func(t *testing.T, l otp.Limiter, key string, p otp.Policy) otp.Result {
+		t.Helper()
+		res, err := l.Allow(ctx, key, p)
+		if err != nil {
+			t.Fatalf("Allow(%q): %v", key, err)
+		}
+		return res
+	}
+	expect := func(t *testing.T, l otp.Limiter, key string, want ...bool) {
+		t.Helper()
+		for i, w := range want {
+			if got := allow(t, l, key, policy).Allowed; got != w {
+				t.Fatalf("call %d on %q: allowed=%v, want %v", i+1, key, got, w)
+			}
+		}
+	}
+
+	t.Run("LimitPerWindow", func(t *testing.T) {
+		l, _ := setup(t)
+		expect(t, l, "a", true, true, true, false, false)
+	})
+
+	t.Run("KeysAreIndependent", func(t *testing.T) {
+		l, _ := setup(t)
+		expect(t, l, "a", true, true, true, false)
+		expect(t, l, "b", true, true, true, false)
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		l, _ := setup(t)
+		for i := 0; i < 20; i++ {
+			if !allow(t, l, "a", otp.Policy{Window: time.Minute}).Allowed {
+				t.Fatalf("call %d denied with Limit 0", i+1)
+			}
+		}
+	})
+
+	t.Run("Result", func(t *testing.T) {
+		l, advance := setup(t)
+		want := func(got otp.Result, allowed bool, remaining int, resetAt time.Time) {
+			t.Helper()
+			if got.Allowed != allowed || got.Limit != policy.Limit || got.Remaining != remaining || !got.ResetAt.Equal(resetAt) {
+				t.Fatalf("got %+v, want allowed=%v limit=%d remaining=%d reset=%v",
+					got, allowed, policy.Limit, remaining, resetAt)
+			}
+		}
+		want(allow(t, l, "a", policy), true, 2, start.Add(policy.Window))
+		advance(time.Minute)
+		want(allow(t, l, "a", policy), true, 1, start.Add(policy.Window))
+		want(allow(t, l, "a", policy), true, 0, start.Add(policy.Window))
+		want(allow(t, l, "a", policy), false, 0, start.Add(policy.Window))
+		advance(policy.Window - time.Second) // only the first event expired
+		want(allow(t, l, "a", policy), true, 0, start.Add(time.Minute+policy.Window))
+	})
+
+	// A fixed window would reset everything at the boundary; a sliding one only
+	// frees the slots of events older than the window.
+	t.Run("SlidingWindow", func(t *testing.T) {
+		l, advance := setup(t)
+		expect(t, l, "a", true, true)
+		advance(policy.Window / 2)
+		expect(t, l, "a", true, false)
+		advance(policy.Window/2 + time.Second) // first two events expired
+		expect(t, l, "a", true, true, false)
+		advance(policy.Window / 2) // the mid-window event expired too
+		expect(t, l, "a", true, false)
+	})
+
+	t.Run("DeniedCallsAreNotCounted", func(t *testing.T) {
+		l, advance := setup(t)
+		expect(t, l, "a", true, true, true)
+		advance(policy.Window / 2)
+		expect(t, l, "a", false, false, false, false)
+		advance(policy.Window/2 + time.Second)
+		expect(t, l, "a", true, true, true, false)
+	})
+
+	t.Run("Peek", func(t *testing.T) {
+		l, advance := setup(t)
+		peek := func(key string, p otp.Policy, want otp.Result) {
+			t.Helper()
+			got, err := l.Peek(ctx, key, p)
+			if err != nil {
+				t.Fatalf("Peek(%q): %v", key, err)
+			}
+			if got.Allowed != want.Allowed || got.Limit != want.Limit || got.Remaining != want.Remaining || !got.ResetAt.Equal(want.ResetAt) {
+				t.Fatalf("Peek(%q) = %+v, want %+v", key, got, want)
+			}
+		}
+		peek("a", policy, otp.Result{Allowed: true, Limit: 3, Remaining: 3, ResetAt: start})
+		peek("a", otp.Policy{Window: time.Minute}, otp.Result{Allowed: true})
+		allow(t, l, "a", policy)
+		advance(time.Minute)
+		for range 2 { // peeking does not count
+			peek("a", policy, otp.Result{Allowed: true, Limit: 3, Remaining: 2, ResetAt: start.Add(policy.Window)})
+		}
+		expect(t, l, "a", true, true, false)
+		peek("a", policy, otp.Result{Limit: 3, ResetAt: start.Add(policy.Window)})
+		advance(policy.Window)
+		peek("a", policy, otp.Result{Allowed: true, Limit: 3, Remaining: 3, ResetAt: start.Add(time.Minute + policy.Window)})
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		l, _ := setup(t)
+		expect(t, l, "a", true, true, true, false)
+		expect(t, l, "b", true, true, true, false)
+		if err := l.Reset(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Reset(ctx, "unknown"); err != nil {
+			t.Fatalf("Reset of an unknown key: %v", err)
+		}
+		expect(t, l, "a", true, true, true, false)
+		expect(t, l, "b", false)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		l, _ := setup(t)
+		const n = 50
+		var (
+			wg      sync.WaitGroup
+			allowed atomic.Int32
+			start   = make(chan struct{})
+		)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				res, err := l.Allow(ctx, "a", policy)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Allowed {
+					allowed.Add(1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if got := allowed.Load(); got != int32(policy.Limit) {
+			t.Fatalf("%d of %d concurrent calls allowed, want %d", got, n, policy.Limit)
+		}
+	})
+}
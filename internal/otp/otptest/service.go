@@ -0,0 +1,379 @@
+package otptest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// NewServiceFunc returns a fresh otp.Service built from opts whose clock reads
+// start, and a function moving that clock forward to a later time.
+type NewServiceFunc func(t *testing.T, opts otp.Options, start time.Time) (s otp.Service, setNow func(time.Time))
+
+type nopSender struct{}
+
+func (nopSender) Send(context.Context, string, string, string, time.Duration) error { return nil }
+
+// TestResendCooldown checks the progressive resend cooldown every otp.Service must have.
+func TestResendCooldown(t *testing.T, newService NewServiceFunc) {
+	ctx := context.Background()
+	const phone = "+989121234567"
+	opts := otp.Options{
+		TTL:             2 * time.Minute,
+		MaxAttempts:     3,
+		Hasher:          otp.NewHasher("secret", nil),
+		Sender:          nopSender{},
+		ResendCooldowns: []time.Duration{30 * time.Second, time.Minute},
+		ResendReset:     time.Hour,
+	}
+	setup := func(t *testing.T) (otp.Service, func(time.Duration)) {
+		start := time.Now().Truncate(time.Millisecond)
+		s, setNow := newService(t, opts, start)
+		now := start
+		return s, func(d time.Duration) {
+			now = now.Add(d)
+			setNow(now)
+		}
+	}
+	issue := func(t *testing.T, s otp.Service, resendIn time.Duration) otp.Issued {
+		t.Helper()
+		iss, err := s.Generate(ctx, otp.PurposeLogin, phone)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if iss.Code == "" || iss.ExpiresIn != opts.TTL || iss.ResendIn != resendIn {
+			t.Fatalf("Generate = %+v, want a code expiring in %v, resend in %v", iss, opts.TTL, resendIn)
+		}
+		return iss
+	}
+	cooling := func(t *testing.T, s otp.Service, wait time.Duration) {
+		t.Helper()
+		if got, err := s.Cooldown(ctx, otp.PurposeLogin, phone); err != nil || got != wait {
+			t.Fatalf("Cooldown = %v, %v; want %v", got, err, wait)
+		}
+		_, err := s.Generate(ctx, otp.PurposeLogin, phone)
+		var ce *otp.CooldownError
+		if !errors.As(err, &ce) || ce.Wait != wait || !errors.Is(err, otp.ErrCooldown) {
+			t.Fatalf("Generate during cooldown: err = %v, want CooldownError{%v}", err, wait)
+		}
+	}
+
+	t.Run("Backoff", func(t *testing.T) {
+		s, advance := setup(t)
+		if got, err := s.Cooldown(ctx, otp.PurposeLogin, phone); err != nil || got != 0 {
+			t.Fatalf("Cooldown before any send = %v, %v", got, err)
+		}
+		issue(t, s, 30*time.Second)
+		cooling(t, s, 30*time.Second)
+		advance(10 * time.Second)
+		cooling(t, s, 20*time.Second)
+		advance(20 * time.Second)
+		issue(t, s, time.Minute)
+		advance(59 * time.Second)
+		cooling(t, s, time.Second)
+		advance(time.Second)
+		issue(t, s, time.Minute) // the last step repeats
+	})
+
+	t.Run("CooldownKeepsPendingCode", func(t *testing.T) {
+		s, _ := setup(t)
+		iss := issue(t, s, 30*time.Second)
+		cooling(t, s, 30*time.Second)
+		if ok, err := s.Validate(ctx, otp.PurposeLogin, phone, iss.Code); err != nil || !ok {
+			t.Fatalf("Validate after a refused resend = %v, %v; want true", ok, err)
+		}
+	})
+
+	t.Run("ValidateRestartsSequence", func(t *testing.T) {
+		s, advance := setup(t)
+		issue(t, s, 30*time.Second)
+		advance(30 * time.Second)
+		iss := issue(t, s, time.Minute)
+		if ok, err := s.Validate(ctx, otp.PurposeLogin, phone, iss.Code); err != nil || !ok {
+			t.Fatalf("Validate = %v, %v", ok, err)
+		}
+		issue(t, s, 30*time.Second)
+	})
+
+	t.Run("SequenceExpires", func(t *testing.T) {
+		s, advance := setup(t)
+		issue(t, s, 30*time.Second)
+		advance(30 * time.Second)
+		issue(t, s, time.Minute)
+		advance(opts.ResendReset + time.Second)
+		issue(t, s, 30*time.Second)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		o := opts
+		o.ResendCooldowns = nil
+		s, _ := newService(t, o, time.Now())
+		for i := 0; i < 3; i++ {
+			iss, err := s.Generate(ctx, otp.PurposeLogin, phone)
+			if err != nil || iss.ResendIn != 0 {
+				t.Fatalf("Generate %d without cooldown = %+v, %v", i+1, iss, err)
+			}
+		}
+	})
+}
+
+// TestPendingAndCancel checks the support operations every otp.Service must have.
+func TestPendingAndCancel(t *testing.T, newService NewServiceFunc) {
+	ctx := context.Background()
+	const phone = "+989121234567"
+	opts := otp.Options{
+		TTL:             2 * time.Minute,
+		MaxAttempts:     3,
+		Hasher:          otp.NewHasher("secret", nil),
+		Sender:          nopSender{},
+		ResendCooldowns: []time.Duration{30 * time.Second, time.Minute},
+		ResendReset:     time.Hour,
+	}
+	setup := func(t *testing.T) (otp.Service, func(time.Duration)) {
+		start := time.Now().Truncate(time.Millisecond)
+		s, setNow := newService(t, opts, start)
+		now := start
+		return s, func(d time.Duration) {
+			now = now.Add(d)
+			setNow(now)
+		}
+	}
+	pending := func(t *testing.T, s otp.Service, want *otp.Pending) {
+		t.Helper()
+		got, err := s.Pending(ctx, otp.PurposeLogin, phone)
+		if err != nil {
+			t.Fatalf("Pending: %v", err)
+		}
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Fatalf("Pending = %+v, want %+v", got, want)
+		}
+	}
+	cancel := func(t *testing.T, s otp.Service, want bool) {
+		t.Helper()
+		if got, err := s.Cancel(ctx, otp.PurposeLogin, phone); err != nil || got != want {
+			t.Fatalf("Cancel = %v, %v; want %v", got, err, want)
+		}
+	}
+
+	t.Run("Pending", func(t *testing.T) {
+		s, advance := setup(t)
+		pending(t, s, nil)
+		if _, err := s.Generate(ctx, otp.PurposeLogin, phone); err != nil {
+			t.Fatal(err)
+		}
+		pending(t, s, &otp.Pending{ExpiresIn: opts.TTL})
+		advance(30 * time.Second)
+		if _, err := s.Validate(ctx, otp.PurposeLogin, phone, "wrong"); err != nil {
+			t.Fatal(err)
+		}
+		pending(t, s, &otp.Pending{ExpiresIn: opts.TTL - 30*time.Second, Attempts: 1})
+		for range 2 {
+			_, _ = s.Validate(ctx, otp.PurposeLogin, phone, "wrong")
+		}
+		pending(t, s, &otp.Pending{ExpiresIn: opts.TTL - 30*time.Second, Attempts: 3, Locked: true})
+		advance(opts.TTL)
+		pending(t, s, nil)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		s, _ := setup(t)
+		cancel(t, s, false)
+		iss, err := s.Generate(ctx, otp.PurposeLogin, phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel(t, s, true)
+		pending(t, s, nil)
+		if ok, err := s.Validate(ctx, otp.PurposeLogin, phone, iss.Code); err != nil || ok {
+			t.Fatalf("Validate of a cancelled code = %v, %v", ok, err)
+		}
+		// the cooldown sequence restarts too
+		if wait, err := s.Cooldown(ctx, otp.PurposeLogin, phone); err != nil || wait != 0 {
+			t.Fatalf("Cooldown after Cancel = %v, %v", wait, err)
+		}
+		if iss, err := s.Generate(ctx, otp.PurposeLogin, phone); err != nil || iss.ResendIn != opts.ResendCooldowns[0] {
+			t.Fatalf("Generate after Cancel = %+v, %v", iss, err)
+		}
+	})
+
+	t.Run("CancelExpired", func(t *testing.T) {
+		s, advance := setup(t)
+		if _, err := s.Generate(ctx, otp.PurposeLogin, phone); err != nil {
+			t.Fatal(err)
+		}
+		advance(opts.TTL + time.Second)
+		cancel(t, s, false)
+	})
+}
+
+type countingSender struct {
+	sent atomic.Int32
+
+	mu       sync.Mutex
+	purposes []string
+}
+
+func (s *countingSender) Send(_ context.Context, _, _, purpose string, _ time.Duration) error {
+	s.sent.Add(1)
+	s.mu.Lock()
+	s.purposes = append(s.purposes, purpose)
+	s.mu.Unlock()
+	return nil
+}
+
+// failingSender refuses to deliver while fail is set.
+type failingSender struct{ fail atomic.Bool }
+
+var errUndelivered = errors.New("gateway down")
+
+func (s *failingSender) Send(context.Context, string, string, string, time.Duration) error {
+	if s.fail.Load() {
+		return errUndelivered
+	}
+	return nil
+}
+
+type fixedCodes map[string]string
+
+func (f fixedCodes) TestCode(_ context.Context, phone string) (string, bool, error) {
+	code, ok := f[phone]
+	return code, ok, nil
+}
+
+// TestTestNumbers checks that test numbers get their fixed code and are never delivered.
+func TestTestNumbers(t *testing.T, newService NewServiceFunc) {
+	ctx := context.Background()
+	const testPhone, phone = "+989990000001", "+989121234567"
+	sender := &countingSender{}
+	s, _ := newService(t, otp.Options{
+		TTL:         2 * time.Minute,
+		MaxAttempts: 3,
+		Hasher:      otp.NewHasher("secret", nil),
+		Sender:      sender,
+		TestNumbers: fixedCodes{testPhone: "424242"},
+	}, time.Now())
+
+	iss, err := s.Generate(ctx, otp.PurposeLogin, testPhone)
+	if err != nil || iss.Code != "424242" {
+		t.Fatalf("Generate(test number) = %+v, %v; want code 424242", iss, err)
+	}
+	if n := sender.sent.Load(); n != 0 {
+		t.Fatalf("test number delivered %d times", n)
+	}
+	if ok, err := s.Validate(ctx, otp.PurposeLogin, testPhone, "424242"); err != nil || !ok {
+		t.Fatalf("Validate(fixed code) = %v, %v", ok, err)
+	}
+
+	if _, err := s.Generate(ctx, otp.PurposeLogin, phone); err != nil {
+		t.Fatal(err)
+	}
+	if n := sender.sent.Load(); n != 1 {
+		t.Fatalf("regular phone delivered %d times, want 1", n)
+	}
+}
+
+// TestPurposes checks that codes and cooldowns of different purposes are kept apart.
+func TestPurposes(t *testing.T, newService NewServiceFunc) {
+	ctx := context.Background()
+	const phone = "+989121234567"
+	opts := otp.Options{
+		TTL:             2 * time.Minute,
+		PurposeTTLs:     map[string]time.Duration{otp.PurposeChangePhone: 10 * time.Minute},
+		MaxAttempts:     3,
+		Hasher:          otp.NewHasher("secret", nil),
+		Sender:          &countingSender{},
+		ResendCooldowns: []time.Duration{30 * time.Second},
+		ResendReset:     time.Hour,
+	}
+	s, _ := newService(t, opts, time.Now().Truncate(time.Millisecond))
+	sender := opts.Sender.(*countingSender)
+
+	login, err := s.Generate(ctx, otp.PurposeLogin, phone)
+	if err != nil || login.ExpiresIn != opts.TTL {
+		t.Fatalf("Generate(login) = %+v, %v", login, err)
+	}
+	// another purpose has its own cooldown and TTL
+	change, err := s.Generate(ctx, otp.PurposeChangePhone, phone)
+	if err != nil || change.ExpiresIn != 10*time.Minute {
+		t.Fatalf("Generate(change_phone) = %+v, %v", change, err)
+	}
+	// the sender is told the purpose, so the message can name it
+	if got := sender.purposes; len(got) != 2 || got[0] != otp.PurposeLogin || got[1] != otp.PurposeChangePhone {
+		t.Fatalf("sent purposes = %v", got)
+	}
+	if p, err := s.Pending(ctx, otp.PurposeDeleteAccount, phone); err != nil || p != nil {
+		t.Fatalf("Pending(delete_account) = %+v, %v", p, err)
+	}
+	if wait, err := s.Cooldown(ctx, otp.PurposeDeleteAccount, phone); err != nil || wait != 0 {
+		t.Fatalf("Cooldown(delete_account) = %v, %v", wait, err)
+	}
+
+	// a code is only valid for its own purpose
+	if ok, err := s.Validate(ctx, otp.PurposeDeleteAccount, phone, login.Code); err != nil || ok {
+		t.Fatalf("login code accepted for delete_account: %v, %v", ok, err)
+	}
+	if login.Code != change.Code {
+		if ok, err := s.Validate(ctx, otp.PurposeChangePhone, phone, login.Code); err != nil || ok {
+			t.Fatalf("login code accepted for change_phone: %v, %v", ok, err)
+		}
+	}
+	if ok, err := s.Validate(ctx, otp.PurposeLogin, phone, login.Code); err != nil || !ok {
+		t.Fatalf("Validate(login) = %v, %v", ok, err)
+	}
+	// using the login code leaves the change_phone code and cooldown alone
+	if wait, err := s.Cooldown(ctx, otp.PurposeChangePhone, phone); err != nil || wait == 0 {
+		t.Fatalf("Cooldown(change_phone) = %v, %v; want it running", wait, err)
+	}
+	if ok, err := s.Cancel(ctx, otp.PurposeChangePhone, phone); err != nil || !ok {
+		t.Fatalf("Cancel(change_phone) = %v, %v", ok, err)
+	}
+}
+
+// TestSendFailure checks that a code the sender could not deliver neither stays valid
+// nor starts a cooldown, so the user can ask again at once.
+func TestSendFailure(t *testing.T, newService NewServiceFunc) {
+	ctx := context.Background()
+	const phone = "+989121234567"
+	sender := &failingSender{}
+	opts := otp.Options{
+		TTL:             2 * time.Minute,
+		MaxAttempts:     3,
+		Hasher:          otp.NewHasher("secret", nil),
+		Sender:          sender,
+		ResendCooldowns: []time.Duration{30 * time.Second, time.Minute},
+		ResendReset:     time.Hour,
+	}
+	start := time.Now().Truncate(time.Millisecond)
+	s, setNow := newService(t, opts, start)
+	failed := func(t *testing.T) {
+		t.Helper()
+		sender.fail.Store(true)
+		defer sender.fail.Store(false)
+		if _, err := s.Generate(ctx, otp.PurposeLogin, phone); !errors.Is(err, errUndelivered) {
+			t.Fatalf("Generate with a failing sender: err = %v", err)
+		}
+		if wait, err := s.Cooldown(ctx, otp.PurposeLogin, phone); err != nil || wait != 0 {
+			t.Fatalf("Cooldown after a failed send = %v, %v; want 0", wait, err)
+		}
+		if p, err := s.Pending(ctx, otp.PurposeLogin, phone); err != nil || p != nil {
+			t.Fatalf("Pending after a failed send = %+v, %v; want none", p, err)
+		}
+	}
+
+	failed(t)
+	iss, err := s.Generate(ctx, otp.PurposeLogin, phone)
+	if err != nil || iss.ResendIn != 30*time.Second {
+		t.Fatalf("Generate after a failed first send = %+v, %v; want the first cooldown step", iss, err)
+	}
+	setNow(start.Add(30 * time.Second))
+	failed(t)
+	// the failed resend did not count as a step of the sequence
+	if iss, err := s.Generate(ctx, otp.PurposeLogin, phone); err != nil || iss.ResendIn != time.Minute {
+		t.Fatalf("Generate after a failed resend = %+v, %v; want the second cooldown step", iss, err)
+	}
+}
@@ -0,0 +1,17 @@
+package otp
+
+import "slices"
+
+// Purposes keep the codes of different flows apart: a code is only valid for the
+// purpose it was issued for.
+const (
+	PurposeLogin         = "login"          // request-otp / verify-otp login and registration
+	PurposeChangePhone   = "change_phone"   // proves ownership of a new phone number
+	PurposeDeleteAccount = "delete_account" // confirms deleting the account
+)
+
+// Purposes lists every known purpose.
+var Purposes = []string{PurposeLogin, PurposeChangePhone, PurposeDeleteAccount}
+
+// ValidPurpose reports whether p is one of Purposes.
+func ValidPurpose(p string) bool { return slices.Contains(Purposes, p) }
@@ -5,29 +5,59 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
-	
+
 	"github.com/TheAmirMohammad/otp-service/internal/otp"
 )
 
 type limiter struct {
-	rdb    *redis.Client
-	limit  int
-	window time.Duration
+	rdb *redis.Client
 }
 
-func NewLimiter(rdb *redis.Client, limit int, window time.Duration) otp.Limiter {
-	return &limiter{rdb: rdb, limit: limit, window: window}
+func NewLimiter(rdb *redis.Client) otp.Limiter {
+	return &limiter{rdb: rdb}
 }
 
-func (l *limiter) Allow(ctx context.Context, phone string) (bool, error) {
-	key := fmt.Sprintf("rl:otp:%s", phone)
-	n, err := l.rdb.Incr(ctx, key).Result()
+// Allow applies the same sliding window as the memory limiter, atomically in Redis.
+func (l *limiter) Allow(ctx context.Context, key string, p otp.Policy) (otp.Result, error) {
+	if p.Limit <= 0 {
+		return otp.Result{Allowed: true}, nil
+	}
+	out, err := slidingWindowScript.Run(ctx, l.rdb, []string{limiterKey(key)},
+		p.Limit, p.Window.Milliseconds(), uuid.NewString()).Int64Slice()
 	if err != nil {
-		return false, err
+		return otp.Result{}, err
+	}
+	return otp.Result{
+		Allowed:   out[0] == 1,
+		Limit:     p.Limit,
+		Remaining: p.Limit - int(out[1]),
+		ResetAt:   time.UnixMilli(out[2]),
+	}, nil
+}
+
+func (l *limiter) Peek(ctx context.Context, key string, p otp.Policy) (otp.Result, error) {
+	if p.Limit <= 0 {
+		return otp.Result{Allowed: true}, nil
 	}
-	if n == 1 {
-		_ = l.rdb.Expire(ctx, key, l.window).Err()
+	out, err := peekScript.Run(ctx, l.rdb, []string{limiterKey(key)}, p.Window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return otp.Result{}, err
 	}
-	return n <= int64(l.limit), nil
+	return otp.Result{
+		Allowed:   int(out[0]) < p.Limit,
+		Limit:     p.Limit,
+		Remaining: max(p.Limit-int(out[0]), 0),
+		ResetAt:   time.UnixMilli(out[1]),
+	}, nil
+}
+
+func (l *limiter) Reset(ctx context.Context, key string) error {
+	return l.rdb.Del(ctx, limiterKey(key)).Err()
 }
+
+// Close is a no-op: Redis expires the keys and the client belongs to the caller.
+func (l *limiter) Close() error { return nil }
+
+func limiterKey(key string) string { return fmt.Sprintf("rl:%s", key) }
@@ -0,0 +1,41 @@
+package redisotp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/otp/otptest"
+)
+
+func TestLimiter(t *testing.T) {
+	otptest.TestLimiter(t, func(t *testing.T, start time.Time) (otp.Limiter, func(time.Time)) {
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = rdb.Close() })
+		// TIME inside the script follows this clock; FastForward expires keys
+		now := start
+		mr.SetTime(now)
+		return NewLimiter(rdb), func(t time.Time) {
+			mr.FastForward(t.Sub(now))
+			now = t
+			mr.SetTime(now)
+		}
+	})
+}
+
+func TestLimiterReplacesFixedWindowCounter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	if err := mr.Set("rl:otp:phone:+989121234567", "7"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewLimiter(rdb).Allow(t.Context(), "otp:phone:+989121234567", otp.Policy{Limit: 3, Window: time.Minute})
+	if err != nil || !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("Allow = %+v, %v; want allowed with 2 remaining", res, err)
+	}
+}
@@ -2,61 +2,158 @@ package redisotp
 
 import (
 	"context"
-	"crypto/rand"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	
+
 	"github.com/TheAmirMohammad/otp-service/internal/otp"
 )
 
 type manager struct {
-	rdb *redis.Client
-	ttl time.Duration
+	rdb  *redis.Client
+	opts otp.Options
 }
 
-func NewManager(rdb *redis.Client, ttl time.Duration) otp.Service {
-	return &manager{rdb: rdb, ttl: ttl}
+func NewManager(rdb *redis.Client, opts otp.Options) otp.Service {
+	return &manager{rdb: rdb, opts: opts}
 }
 
-func (m *manager) Generate(ctx context.Context, phone string) (string, error) {
-	code, err := genCode()
+// Each pending code is a hash: otp:<phone> -> {code: HMAC of the code, attempts}.
+// The resend cooldown lives next to it: otp:send:<phone> -> {count, next}.
+// Purposes other than login add a segment: otp:<purpose>:<phone>, otp:send:<purpose>:<phone>.
+func (m *manager) Generate(ctx context.Context, purpose, phone string) (otp.Issued, error) {
+	code, test, err := m.opts.TestCode(ctx, phone)
+	if err != nil {
+		return otp.Issued{}, err
+	}
+	if !test {
+		if code, err = m.opts.Format.Generate(); err != nil {
+			return otp.Issued{}, err
+		}
+	}
+	ttl := m.opts.TTLFor(purpose)
+	args := []any{m.opts.Hasher.Hash(phone, code), ttl.Milliseconds(), m.opts.ResendReset.Milliseconds()}
+	for _, cd := range m.opts.ResendCooldowns {
+		args = append(args, cd.Milliseconds())
+	}
+	out, err := generateScript.Run(ctx, m.rdb, []string{otpKey(purpose, phone), sendKey(purpose, phone)}, args...).Int64Slice()
 	if err != nil {
-		return "", err
+		return otp.Issued{}, err
 	}
-	key := fmt.Sprintf("otp:%s", phone)
-	if err := m.rdb.Set(ctx, key, code, m.ttl).Err(); err != nil {
-		return "", err
+	if out[0] == 0 {
+		return otp.Issued{}, &otp.CooldownError{Wait: time.Duration(out[1]) * time.Millisecond}
 	}
-	log.Printf("[OTP] phone=%s code=%s (expires in %s)", phone, code, m.ttl)
-	return code, nil
+	if !test { // test numbers are never delivered
+		if err := m.opts.Sender.Send(ctx, phone, code, purpose, ttl); err != nil {
+			// the code never arrived: don't let it hold the phone in a cooldown
+			undo := unsendScript.Run(context.WithoutCancel(ctx), m.rdb, []string{otpKey(purpose, phone), sendKey(purpose, phone)}, args[0]).Err()
+			return otp.Issued{}, errors.Join(fmt.Errorf("send otp: %w", err), undo)
+		}
+	}
+	return otp.Issued{Code: code, ExpiresIn: ttl, ResendIn: time.Duration(out[1]) * time.Millisecond}, nil
 }
 
-func (m *manager) Validate(ctx context.Context, phone, code string) (bool, error) {
-	key := fmt.Sprintf("otp:%s", phone)
-	val, err := m.rdb.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return false, nil
+func (m *manager) Cooldown(ctx context.Context, purpose, phone string) (time.Duration, error) {
+	ms, err := cooldownScript.Run(ctx, m.rdb, []string{sendKey(purpose, phone)}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (m *manager) Validate(ctx context.Context, purpose, phone, code string) (bool, error) {
+	key, send := otpKey(purpose, phone), sendKey(purpose, phone)
+	res, err := m.validate(ctx, key, send, phone, code)
+	if err == nil && res == resLegacy && m.opts.AcceptLegacy {
+		if err := m.upgradeLegacy(ctx, key, phone, m.opts.TTLFor(purpose)); err != nil {
+			return false, err
+		}
+		res, err = m.validate(ctx, key, send, phone, code)
 	}
 	if err != nil {
 		return false, err
 	}
-	if val != code {
+	switch res {
+	case resOK:
+		return true, nil
+	case resLocked:
+		return false, otp.ErrTooManyAttempts
+	default:
 		return false, nil
 	}
-	if err := m.rdb.Del(ctx, key).Err(); err != nil {
+}
+
+func (m *manager) validate(ctx context.Context, key, send, phone, code string) (int64, error) {
+	args := []any{m.opts.MaxAttempts}
+	for _, h := range m.opts.Hasher.Candidates(phone, code) {
+		args = append(args, h)
+	}
+	return validateScript.Run(ctx, m.rdb, []string{key, send}, args...).Int64()
+}
+
+// upgradeLegacy rewrites a plaintext "otp:<phone>" string written by an older deployment
+// into the hashed layout, keeping its remaining TTL.
+func (m *manager) upgradeLegacy(ctx context.Context, key, phone string, ttl time.Duration) error {
+	val, err := m.rdb.Get(ctx, key).Result()
+	if err == redis.Nil || isWrongType(err) {
+		return nil // gone or already upgraded by a concurrent call
+	}
+	if err != nil {
+		return err
+	}
+	return upgradeScript.Run(ctx, m.rdb, []string{key},
+		val, m.opts.Hasher.Hash(phone, val), ttl.Milliseconds()).Err()
+}
+
+func (m *manager) Pending(ctx context.Context, purpose, phone string) (*otp.Pending, error) {
+	out, err := pendingScript.Run(ctx, m.rdb, []string{otpKey(purpose, phone)}).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 || out[1] <= 0 {
+		return nil, nil
+	}
+	return &otp.Pending{
+		ExpiresIn: time.Duration(out[1]) * time.Millisecond,
+		Attempts:  int(out[0]),
+		Locked:    int(out[0]) >= m.opts.MaxAttempts,
+	}, nil
+}
+
+func (m *manager) Cancel(ctx context.Context, purpose, phone string) (bool, error) {
+	var code *redis.IntCmd
+	if _, err := m.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		code = p.Del(ctx, otpKey(purpose, phone))
+		p.Del(ctx, sendKey(purpose, phone))
+		return nil
+	}); err != nil {
 		return false, err
 	}
-	return true, nil
+	return code.Val() > 0, nil
+}
+
+// Close is a no-op: Redis expires the keys and the client belongs to the caller.
+func (m *manager) Close() error { return nil }
+
+// Login codes keep the keys used before purposes existed, so codes issued during a
+// rolling deploy stay valid.
+func otpKey(purpose, phone string) string {
+	if purpose == otp.PurposeLogin {
+		return fmt.Sprintf("otp:%s", phone)
+	}
+	return fmt.Sprintf("otp:%s:%s", purpose, phone)
 }
 
-func genCode() (string, error) {
-	var b [3]byte
-	if _, err := rand.Read(b[:]); err != nil {
-		return "", err
+func sendKey(purpose, phone string) string {
+	if purpose == otp.PurposeLogin {
+		return fmt.Sprintf("otp:send:%s", phone)
 	}
-	n := (int(b[0])<<16 | int(b[1])<<8 | int(b[2])) % 1000000
-	return fmt.Sprintf("%06d", n), nil
+	return fmt.Sprintf("otp:send:%s:%s", purpose, phone)
+}
+
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
 }
@@ -0,0 +1,165 @@
+package redisotp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/otp/otptest"
+)
+
+type nopSender struct{}
+
+func (nopSender) Send(context.Context, string, string, string, time.Duration) error { return nil }
+
+func newTestManager(t *testing.T) (*miniredis.Miniredis, otp.Service) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, NewManager(rdb, otp.Options{
+		TTL:          time.Minute,
+		MaxAttempts:  3,
+		Hasher:       otp.NewHasher("secret", nil),
+		Sender:       nopSender{},
+		AcceptLegacy: true,
+	})
+}
+
+func TestValidateConcurrentSingleWinner(t *testing.T) {
+	_, m := newTestManager(t)
+	ctx := context.Background()
+	iss, err := m.Generate(ctx, otp.PurposeLogin, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 50
+	var (
+		wg   sync.WaitGroup
+		wins atomic.Int32
+	)
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ok, err := m.Validate(ctx, otp.PurposeLogin, "+989121234567", iss.Code)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				wins.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := wins.Load(); got != 1 {
+		t.Fatalf("expected exactly 1 successful validation, got %d", got)
+	}
+}
+
+func TestValidateLocksAfterMaxAttempts(t *testing.T) {
+	_, m := newTestManager(t)
+	ctx := context.Background()
+	iss, err := m.Generate(ctx, otp.PurposeLogin, "+989121234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if iss.Code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 1; i <= 3; i++ {
+		ok, err := m.Validate(ctx, otp.PurposeLogin, "+989121234567", wrong)
+		if ok {
+			t.Fatal("wrong code accepted")
+		}
+		if i < 3 && err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", i, err)
+		}
+		if i == 3 && err != otp.ErrTooManyAttempts {
+			t.Fatalf("attempt %d: expected ErrTooManyAttempts, got %v", i, err)
+		}
+	}
+	if ok, err := m.Validate(ctx, otp.PurposeLogin, "+989121234567", iss.Code); ok || err != otp.ErrTooManyAttempts {
+		t.Fatalf("locked code: got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestValidateLegacyPlaintext(t *testing.T) {
+	mr, m := newTestManager(t)
+	ctx := context.Background()
+	if err := mr.Set("otp:+989121234567", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	mr.SetTTL("otp:+989121234567", time.Minute)
+
+	ok, err := m.Validate(ctx, otp.PurposeLogin, "+989121234567", "123456")
+	if err != nil || !ok {
+		t.Fatalf("legacy code: got ok=%v err=%v", ok, err)
+	}
+	if mr.Exists("otp:+989121234567") {
+		t.Fatal("legacy code not consumed")
+	}
+}
+
+// Login codes keep the pre-purpose keys so codes issued during a rolling deploy stay valid.
+func TestPurposeKeys(t *testing.T) {
+	mr, m := newTestManager(t)
+	ctx := context.Background()
+	for purpose, key := range map[string]string{
+		otp.PurposeLogin:         "otp:+989121234567",
+		otp.PurposeDeleteAccount: "otp:delete_account:+989121234567",
+	} {
+		if _, err := m.Generate(ctx, purpose, "+989121234567"); err != nil {
+			t.Fatal(err)
+		}
+		if !mr.Exists(key) {
+			t.Errorf("%s: no %s key", purpose, key)
+		}
+	}
+}
+
+func TestResendCooldown(t *testing.T) {
+	otptest.TestResendCooldown(t, newTestService)
+}
+
+func TestPendingAndCancel(t *testing.T) {
+	otptest.TestPendingAndCancel(t, newTestService)
+}
+
+func TestTestNumbers(t *testing.T) {
+	otptest.TestTestNumbers(t, newTestService)
+}
+
+func TestPurposes(t *testing.T) {
+	otptest.TestPurposes(t, newTestService)
+}
+
+func TestSendFailure(t *testing.T) {
+	otptest.TestSendFailure(t, newTestService)
+}
+
+func newTestService(t *testing.T, opts otp.Options, start time.Time) (otp.Service, func(time.Time)) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	now := start
+	mr.SetTime(now)
+	return NewManager(rdb, opts), func(t time.Time) {
+		mr.FastForward(t.Sub(now))
+		now = t
+		mr.SetTime(now)
+	}
+}
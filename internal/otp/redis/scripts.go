@@ -0,0 +1,160 @@
+package redisotp
+
+import "github.com/redis/go-redis/v9"
+
+// Results of validateScript.
+const (
+	resMissing = 0  // no pending code (never issued, expired or consumed)
+	resOK      = 1  // code matched and was consumed
+	resWrong   = -1 // wrong code, attempt counted
+	resLocked  = -2 // attempts exhausted
+	resLegacy  = -3 // plaintext string left by a pre-hashing deployment
+)
+
+// generateScript stores a new code unless the phone's resend cooldown is running,
+// and advances the cooldown sequence. Times use the Redis clock (ms).
+// Returns {1, cooldown ms} when stored, {0, ms left} during the cooldown.
+//
+// KEYS[1] otp key, KEYS[2] send key: {count, next}
+// ARGV[1] code hash, ARGV[2] otp ttl (ms), ARGV[3] sequence reset (ms), ARGV[4..] cooldowns (ms)
+var generateScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local nextAt = tonumber(redis.call('HGET', KEYS[2], 'next') or '0')
+if nextAt > now then return {0, nextAt - now} end
+local count = tonumber(redis.call('HGET', KEYS[2], 'count') or '0') + 1
+local cd = 0
+if #ARGV > 3 then cd = tonumber(ARGV[3 + math.min(count, #ARGV - 3)]) end
+redis.call('HSET', KEYS[2], 'count', count, 'next', now + cd)
+redis.call('PEXPIRE', KEYS[2], math.max(tonumber(ARGV[3]), cd))
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'code', ARGV[1], 'attempts', 0)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {1, cd}
+`)
+
+// unsendScript undoes generateScript when the code could not be delivered: the code is
+// dropped and the cooldown sequence steps back (the previous step had already elapsed,
+// otherwise generateScript would have refused). A newer code is left alone.
+//
+// KEYS[1] otp key, KEYS[2] send key, ARGV[1] hash of the undelivered code
+var unsendScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1])['ok'] ~= 'hash' then return 0 end
+if redis.call('HGET', KEYS[1], 'code') ~= ARGV[1] then return 0 end
+redis.call('DEL', KEYS[1])
+local count = redis.call('HINCRBY', KEYS[2], 'count', -1)
+if count <= 0 then
+  redis.call('DEL', KEYS[2])
+else
+  redis.call('HSET', KEYS[2], 'next', 0)
+end
+return 1
+`)
+
+// cooldownScript returns the ms left before the phone may get a new code (0 if none).
+//
+// KEYS[1] send key
+var cooldownScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local nextAt = tonumber(redis.call('HGET', KEYS[1], 'next') or '0')
+if nextAt > now then return nextAt - now end
+return 0
+`)
+
+// validateScript checks and consumes a code in one server-side step, so two concurrent
+// verifications can never both win. The code is compared against precomputed HMACs
+// (ARGV[2..]), which leaks nothing useful through timing.
+//
+// A successful check also restarts the phone's resend cooldown sequence.
+//
+// KEYS[1] otp key, KEYS[2] send key, ARGV[1] max attempts, ARGV[2..] accepted hashes
+var validateScript = redis.NewScript(`
+local t = redis.call('TYPE', KEYS[1])['ok']
+if t == 'none' then return 0 end
+if t ~= 'hash' then return -3 end
+local max = tonumber(ARGV[1])
+local attempts = tonumber(redis.call('HGET', KEYS[1], 'attempts') or '0')
+if attempts >= max then return -2 end
+local stored = redis.call('HGET', KEYS[1], 'code')
+for i = 2, #ARGV do
+  if stored == ARGV[i] then
+    redis.call('DEL', KEYS[1], KEYS[2])
+    return 1
+  end
+end
+attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if attempts >= max then return -2 end
+return -1
+`)
+
+// upgradeScript swaps a legacy plaintext code for its hashed record, but only if the
+// key still holds the plaintext we read (compare-and-swap), keeping the remaining TTL.
+//
+// KEYS[1] otp key, ARGV[1] plaintext read by the caller, ARGV[2] hash, ARGV[3] fallback ttl (ms)
+var upgradeScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1])['ok'] ~= 'string' then return 0 end
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then return 0 end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl <= 0 then ttl = tonumber(ARGV[3]) end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'code', ARGV[2], 'attempts', 0)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`)
+
+// slidingWindowScript is a sliding log limiter: every allowed event is a sorted-set
+// member scored by the Redis server clock (ms), so replicas with skewed clocks agree.
+// Denied events are not recorded. Returns {allowed (1|0), events in window, reset (unix ms)}
+// where reset is when the oldest event leaves the window.
+//
+// KEYS[1] limiter key, ARGV[1] limit, ARGV[2] window (ms), ARGV[3] unique member id
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[2])
+local kind = redis.call('TYPE', KEYS[1])['ok']
+if kind ~= 'zset' and kind ~= 'none' then
+  -- counter left by the old fixed-window limiter
+  redis.call('DEL', KEYS[1])
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local n = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if n < tonumber(ARGV[1]) then
+  redis.call('ZADD', KEYS[1], now, ARGV[3])
+  redis.call('PEXPIRE', KEYS[1], window)
+  n = n + 1
+  allowed = 1
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {allowed, n, tonumber(oldest[2]) + window}
+`)
+
+// peekScript reads a sliding log like slidingWindowScript without recording an event.
+// Returns {events in window, reset (unix ms)}; reset is now when the window is empty.
+//
+// KEYS[1] limiter key, ARGV[1] window (ms)
+var peekScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+if redis.call('TYPE', KEYS[1])['ok'] ~= 'zset' then return {0, now} end
+local live = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. (now - window), '+inf', 'WITHSCORES', 'LIMIT', 0, 1)
+if #live == 0 then return {0, now} end
+local n = redis.call('ZCOUNT', KEYS[1], '(' .. (now - window), '+inf')
+return {n, tonumber(live[2]) + window}
+`)
+
+// pendingScript describes the outstanding code without revealing it.
+// Returns {} when there is none, else {attempts, ttl ms}. Legacy plaintext codes
+// report no attempts.
+//
+// KEYS[1] otp key
+var pendingScript = redis.NewScript(`
+local t = redis.call('TYPE', KEYS[1])['ok']
+if t == 'none' then return {} end
+local attempts = 0
+if t == 'hash' then attempts = tonumber(redis.call('HGET', KEYS[1], 'attempts') or '0') end
+return {attempts, redis.call('PTTL', KEYS[1])}
+`)
@@ -0,0 +1,50 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// OutboxEntry is one JSON line written by the file sender.
+type OutboxEntry struct {
+	Phone     string    `json:"phone"`
+	Code      string    `json:"code"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+type file struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFile appends every code to an outbox file (one JSON object per line),
+// handy for tests and local tooling that need to read the code back.
+func NewFile(path string) otp.Sender { return &file{path: path} }
+
+func (f *file) Send(_ context.Context, phone, code, purpose string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	b, err := json.Marshal(OutboxEntry{Phone: phone, Code: code, Purpose: purpose, ExpiresAt: now.Add(ttl), SentAt: now})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fh, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open outbox: %w", err)
+	}
+	if _, err := fh.Write(append(b, '\n')); err != nil {
+		_ = fh.Close()
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	return fh.Close()
+}
@@ -0,0 +1,75 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+type webhook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// messages introduce the code of each purpose, so a code is not entered in the wrong flow
+var messages = map[string]string{
+	otp.PurposeLogin:         "Your login code is",
+	otp.PurposeChangePhone:   "Your code to confirm this new phone number is",
+	otp.PurposeDeleteAccount: "Your code to confirm deleting your account is",
+}
+
+func message(purpose string) string {
+	if m, ok := messages[purpose]; ok {
+		return m
+	}
+	return "Your verification code is"
+}
+
+type webhookPayload struct {
+	To      string `json:"to"`
+	Message string `json:"message"`
+	Purpose string `json:"purpose"` // for gateways with a template per purpose
+}
+
+// NewWebhook POSTs {"to","message","purpose"} as JSON to a generic SMS gateway.
+// If token is set it is sent as "Authorization: Bearer <token>".
+func NewWebhook(url, token string, timeout time.Duration) otp.Sender {
+	return &webhook{url: url, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+func (w *webhook) Send(ctx context.Context, phone, code, purpose string, ttl time.Duration) error {
+	body, err := json.Marshal(webhookPayload{
+		To:      phone,
+		Message: fmt.Sprintf("%s %s. It expires in %s.", message(purpose), code, ttl),
+		Purpose: purpose,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build sms request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms gateway: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms gateway: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var (
+		got  webhookPayload
+		auth string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s, want POST application/json", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	s := NewWebhook(srv.URL, "gw-token", time.Second)
+	if err := s.Send(context.Background(), "+989121111111", "123456", otp.PurposeDeleteAccount, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got.To != "+989121111111" || got.Purpose != otp.PurposeDeleteAccount {
+		t.Errorf("payload = %+v", got)
+	}
+	if !strings.HasPrefix(got.Message, messages[otp.PurposeDeleteAccount]+" 123456.") || !strings.Contains(got.Message, "2m0s") {
+		t.Errorf("message = %q", got.Message)
+	}
+	if auth != "Bearer gw-token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	// no token, no header; unknown purposes still get a message
+	if err := NewWebhook(srv.URL, "", time.Second).Send(context.Background(), "+989121111111", "123456", "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" || got.Purpose != "other" || !strings.HasPrefix(got.Message, "Your verification code is 123456.") {
+		t.Errorf("without token: Authorization = %q, payload = %+v", auth, got)
+	}
+}
+
+func TestWebhookSendErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"server error", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, "unexpected status 502"},
+		{"redirect to nowhere", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusMultipleChoices)
+		}, "unexpected status 300"},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(500 * time.Millisecond):
+			}
+		}, "sms gateway"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(tc.handler)
+			defer srv.Close()
+			err := NewWebhook(srv.URL, "", 50*time.Millisecond).Send(context.Background(), "+989121111111", "123456", otp.PurposeLogin, time.Minute)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Send = %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		defer srv.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := NewWebhook(srv.URL, "", time.Second).Send(ctx, "+989121111111", "123456", otp.PurposeLogin, time.Minute); err == nil {
+			t.Fatal("Send with a canceled context succeeded")
+		}
+	})
+}
@@ -1,14 +1,74 @@
 package otp
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
-// OTP service interface (both memory & redis implement)
+// Issued describes a code that was generated and handed to the Sender.
+type Issued struct {
+	Code      string
+	ExpiresIn time.Duration // until the code stops validating
+	ResendIn  time.Duration // until another code may be requested (0 without cooldown)
+}
+
+// Pending describes a phone's outstanding code for support tooling; never the code itself.
+type Pending struct {
+	ExpiresIn time.Duration // until the code stops validating
+	Attempts  int           // wrong guesses so far
+	Locked    bool          // attempts exhausted: Validate fails until it expires or is cancelled
+}
+
+// OTP service interface (both memory & redis implement).
+// Codes and resend cooldowns are kept per purpose (see Purposes) and phone.
 type Service interface {
-	Generate(ctx context.Context, phone string) (string, error)
-	Validate(ctx context.Context, phone, code string) (bool, error)
+	// Generate fails with a *CooldownError while the previous code's resend cooldown runs.
+	Generate(ctx context.Context, purpose, phone string) (Issued, error)
+	Validate(ctx context.Context, purpose, phone, code string) (bool, error)
+	// Cooldown returns how long the phone must wait before Generate succeeds (0 if it may now).
+	Cooldown(ctx context.Context, purpose, phone string) (time.Duration, error)
+	// Pending returns the phone's outstanding code, or nil if there is none.
+	Pending(ctx context.Context, purpose, phone string) (*Pending, error)
+	// Cancel drops the phone's outstanding code and restarts its resend cooldown
+	// sequence, reporting whether a code was pending.
+	Cancel(ctx context.Context, purpose, phone string) (bool, error)
+	// Close stops background work (the memory janitor); it does not close shared clients.
+	Close() error
+}
+
+// Policy is a rate limit budget: at most Limit events per Window. Limit <= 0 means unlimited.
+type Policy struct {
+	Limit  int
+	Window time.Duration
 }
 
-// Rate limiter interface (both memory & redis implement)
+// Result is a limiter decision plus the state of the key's budget after it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	ResetAt   time.Time // when the oldest counted event leaves the window, freeing a slot
+}
+
+// Rate limiter interface (both memory & redis implement).
+// Keys are namespaced by the caller (e.g. "otp:phone:+98...", "totp:<user id>").
+// With an unlimited policy the result is Allowed with a zero Limit.
 type Limiter interface {
-	Allow(ctx context.Context, phone string) (bool, error)
+	Allow(ctx context.Context, key string, p Policy) (Result, error)
+	// Peek returns the key's budget as Allow would see it, without counting an event.
+	Peek(ctx context.Context, key string, p Policy) (Result, error)
+	// Reset forgets every event counted for key.
+	Reset(ctx context.Context, key string) error
+	Close() error
+}
+
+// TestNumbers supplies the fixed code of QA / app review phones; such codes are never sent.
+type TestNumbers interface {
+	TestCode(ctx context.Context, phone string) (code string, ok bool, err error)
+}
+
+// Sender delivers a freshly generated code to the phone owner (console, file, sms gateway...).
+// purpose (see Purposes) lets the message say what the code is for.
+type Sender interface {
+	Send(ctx context.Context, phone, code, purpose string, ttl time.Duration) error
 }
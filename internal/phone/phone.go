@@ -0,0 +1,89 @@
+// Package phone turns user-typed phone numbers into canonical E.164 strings
+// (e.g. "+989121234567") and applies the per-country policy.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+var (
+	ErrInvalid        = errors.New("invalid phone number")
+	ErrCountryBlocked = errors.New("phone country not allowed")
+)
+
+// Normalizer parses numbers with a default region for national formats
+// ("0912...") and enforces the allow/deny lists of country calling codes.
+type Normalizer struct {
+	region string
+	allow  map[int32]bool // empty allows every country not denied
+	deny   map[int32]bool
+}
+
+// NewNormalizer validates the region (ISO 3166 alpha-2, e.g. "IR") and the
+// calling codes ("98" or "+98") of both lists.
+func NewNormalizer(defaultRegion string, allow, deny []string) (*Normalizer, error) {
+	region := strings.ToUpper(strings.TrimSpace(defaultRegion))
+	if phonenumbers.GetCountryCodeForRegion(region) == 0 {
+		return nil, fmt.Errorf("phone: unknown default region %q", defaultRegion)
+	}
+	n := &Normalizer{region: region}
+	var err error
+	if n.allow, err = callingCodes(allow); err != nil {
+		return nil, err
+	}
+	if n.deny, err = callingCodes(deny); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Normalize returns raw in E.164. It fails with ErrInvalid for anything that is
+// not a valid number and ErrCountryBlocked when the country policy rejects it.
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > 32 {
+		return "", ErrInvalid
+	}
+	num, err := phonenumbers.Parse(raw, n.region)
+	if err != nil || !phonenumbers.IsValidNumber(num) {
+		return "", ErrInvalid
+	}
+	cc := num.GetCountryCode()
+	if n.deny[cc] || (len(n.allow) > 0 && !n.allow[cc]) {
+		return "", ErrCountryBlocked
+	}
+	return phonenumbers.Format(num, phonenumbers.E164), nil
+}
+
+func callingCodes(list []string) (map[int32]bool, error) {
+	out := map[int32]bool{}
+	for _, s := range list {
+		cc, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(s), "+"), 10, 32)
+		if err != nil || phonenumbers.GetRegionCodeForCountryCode(int(cc)) == phonenumbers.UNKNOWN_REGION {
+			return nil, fmt.Errorf("phone: invalid country calling code %q", s)
+		}
+		out[int32(cc)] = true
+	}
+	return out, nil
+}
+
+// SearchTerm rewrites a possibly partial number in national format to the E.164
+// prefix it is stored under (with region IR, "0912" becomes "+98912"), so admin
+// searches match normalized rows. Anything else is returned as typed, trimmed.
+func (n *Normalizer) SearchTerm(raw string) string {
+	s := strings.TrimSpace(raw)
+	if e164, err := n.Normalize(s); err == nil {
+		return e164
+	}
+	digits := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(s)
+	ndd := phonenumbers.GetNddPrefixForRegion(n.region, true)
+	if ndd == "" || !strings.HasPrefix(digits, ndd) || strings.Trim(digits, "0123456789") != "" {
+		return s
+	}
+	return fmt.Sprintf("+%d%s", phonenumbers.GetCountryCodeForRegion(n.region), digits[len(ndd):])
+}
@@ -0,0 +1,46 @@
+package phone
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	n, err := NewNormalizer("IR", nil, []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		raw, want string
+		err       error
+	}{
+		{"+989121234567", "+989121234567", nil},
+		{"09121234567", "+989121234567", nil},
+		{"989121234567", "+989121234567", nil},
+		{"0912 123 4567", "+989121234567", nil},
+		{"+12025550123", "", ErrCountryBlocked},
+		{"0912", "", ErrInvalid},
+		{"", "", ErrInvalid},
+	} {
+		got, err := n.Normalize(tc.raw)
+		if got != tc.want || err != tc.err {
+			t.Errorf("Normalize(%q) = %q, %v; want %q, %v", tc.raw, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestSearchTerm(t *testing.T) {
+	n, err := NewNormalizer("IR", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for raw, want := range map[string]string{
+		"09121234567": "+989121234567",
+		"0912":        "+98912",
+		" 0912-12 ":   "+9891212",
+		"+98912":      "+98912",
+		"1234":        "1234",
+		"0912abc":     "0912abc",
+	} {
+		if got := n.SearchTerm(raw); got != want {
+			t.Errorf("SearchTerm(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
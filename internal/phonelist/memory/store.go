@@ -0,0 +1,54 @@
+package memoryphonelist
+
+import (
+	"context"
+	"sync"
+
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+)
+
+type store struct {
+	mu      sync.RWMutex
+	entries map[string]phonelist.Entry
+}
+
+func NewStore() phonelist.Store {
+	return &store{entries: make(map[string]phonelist.Entry)}
+}
+
+func (s *store) Get(_ context.Context, patterns []string) ([]*phonelist.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*phonelist.Entry, len(patterns))
+	for i, p := range patterns {
+		if e, ok := s.entries[p]; ok {
+			out[i] = &e
+		}
+	}
+	return out, nil
+}
+
+func (s *store) List(context.Context) ([]phonelist.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]phonelist.Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *store) Put(_ context.Context, e phonelist.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.Pattern] = e
+	return nil
+}
+
+func (s *store) Delete(_ context.Context, pattern string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[pattern]
+	delete(s.entries, pattern)
+	return ok, nil
+}
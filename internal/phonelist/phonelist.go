@@ -0,0 +1,194 @@
+// Package phonelist is the managed list of phone numbers and prefixes that get
+// special treatment: blocked ones, allowed exceptions and QA test numbers with a
+// fixed code. Entries come from config (read-only) and from the admin API (a Store).
+package phonelist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+)
+
+// Entry kinds
+const (
+	KindBlock = "block" // request-otp and verify-otp are refused
+	KindAllow = "allow" // exception inside a blocked prefix
+	KindTest  = "test"  // fixed Code, never delivered (exact numbers only)
+)
+
+// Entry sources
+const (
+	SourceConfig = "config"
+	SourceAdmin  = "admin"
+)
+
+var (
+	ErrBlocked  = errors.New("phone blocked")
+	ErrInvalid  = errors.New("invalid phone list entry")
+	ErrReadOnly = errors.New("entry comes from config")
+)
+
+var patternRx = regexp.MustCompile(`^\+[1-9]\d{0,14}\*?$`)
+
+// Entry matches one E.164 number ("+989121234567") or every number with a prefix ("+98912*").
+type Entry struct {
+	Pattern   string    `json:"pattern"`
+	Kind      string    `json:"kind"`           // block | allow | test
+	Code      string    `json:"code,omitempty"` // test numbers only
+	Note      string    `json:"note,omitempty"`
+	Source    string    `json:"source"` // config | admin
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// IsPrefix reports whether the entry covers a prefix rather than one number.
+func (e Entry) IsPrefix() bool { return strings.HasSuffix(e.Pattern, "*") }
+
+// Validate checks the pattern and the kind-specific fields; test codes must have the
+// format of generated codes.
+func (e Entry) Validate(f otp.Format) error {
+	if !patternRx.MatchString(e.Pattern) {
+		return fmt.Errorf("%w: pattern must be +digits or +digits*", ErrInvalid)
+	}
+	switch e.Kind {
+	case KindBlock, KindAllow:
+		if e.Code != "" {
+			return fmt.Errorf("%w: only test numbers have a code", ErrInvalid)
+		}
+	case KindTest:
+		if e.IsPrefix() {
+			return fmt.Errorf("%w: test numbers must be exact", ErrInvalid)
+		}
+		if !f.Valid(e.Code) {
+			return fmt.Errorf("%w: test code must be %s", ErrInvalid, f)
+		}
+	default:
+		return fmt.Errorf("%w: kind must be block, allow or test", ErrInvalid)
+	}
+	if len(e.Note) > 200 {
+		return fmt.Errorf("%w: note too long", ErrInvalid)
+	}
+	return nil
+}
+
+// Store keeps the entries managed through the admin API (both memory & redis implement).
+type Store interface {
+	// Get returns the entries of the given patterns, nil where there is none.
+	Get(ctx context.Context, patterns []string) ([]*Entry, error)
+	List(ctx context.Context) ([]Entry, error)
+	Put(ctx context.Context, e Entry) error
+	Delete(ctx context.Context, pattern string) (bool, error)
+}
+
+// List combines the config entries with the Store. The most specific entry wins:
+// an exact number over any prefix, a longer prefix over a shorter one.
+type List struct {
+	static map[string]Entry
+	store  Store
+	format otp.Format // of test codes
+}
+
+// New validates the config entries; a pattern may appear only once.
+func New(store Store, static []Entry, format otp.Format) (*List, error) {
+	l := &List{static: map[string]Entry{}, store: store, format: format}
+	for _, e := range static {
+		e.Code = format.Canonical(e.Code)
+		if err := e.Validate(format); err != nil {
+			return nil, fmt.Errorf("phone list %s: %w", e.Pattern, err)
+		}
+		if _, dup := l.static[e.Pattern]; dup {
+			return nil, fmt.Errorf("phone list %s: listed twice", e.Pattern)
+		}
+		e.Source = SourceConfig
+		l.static[e.Pattern] = e
+	}
+	return l, nil
+}
+
+// Match returns the most specific entry covering phone (E.164), or nil.
+func (l *List) Match(ctx context.Context, phone string) (*Entry, error) {
+	patterns := candidates(phone)
+	stored, err := l.store.Get(ctx, patterns)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range patterns {
+		if e, ok := l.static[p]; ok {
+			return &e, nil
+		}
+		if stored[i] != nil {
+			return stored[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Check fails with ErrBlocked when phone is blocked and returns its entry otherwise (nil if none).
+func (l *List) Check(ctx context.Context, phone string) (*Entry, error) {
+	e, err := l.Match(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil && e.Kind == KindBlock {
+		return e, ErrBlocked
+	}
+	return e, nil
+}
+
+// TestCode implements otp.TestNumbers.
+func (l *List) TestCode(ctx context.Context, phone string) (string, bool, error) {
+	e, err := l.Match(ctx, phone)
+	if err != nil || e == nil || e.Kind != KindTest {
+		return "", false, err
+	}
+	return e.Code, true, nil
+}
+
+// Entries lists config and admin entries, sorted by pattern.
+func (l *List) Entries(ctx context.Context) ([]Entry, error) {
+	out, err := l.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range l.static {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Pattern < out[j].Pattern })
+	return out, nil
+}
+
+// Put adds or replaces an admin entry. Config entries are read-only.
+func (l *List) Put(ctx context.Context, e Entry) (Entry, error) {
+	e.Code = l.format.Canonical(e.Code)
+	if err := e.Validate(l.format); err != nil {
+		return Entry{}, err
+	}
+	if _, ok := l.static[e.Pattern]; ok {
+		return Entry{}, ErrReadOnly
+	}
+	e.Source, e.CreatedAt = SourceAdmin, time.Now().UTC()
+	return e, l.store.Put(ctx, e)
+}
+
+// Delete removes an admin entry, reporting whether it existed.
+func (l *List) Delete(ctx context.Context, pattern string) (bool, error) {
+	if _, ok := l.static[pattern]; ok {
+		return false, ErrReadOnly
+	}
+	return l.store.Delete(ctx, pattern)
+}
+
+// candidates lists the patterns that may cover phone, most specific first:
+// the number itself, then its prefixes from longest to shortest ("+9*").
+func candidates(phone string) []string {
+	out := []string{phone}
+	for i := len(phone); i >= 2; i-- {
+		out = append(out, phone[:i]+"*")
+	}
+	return out
+}
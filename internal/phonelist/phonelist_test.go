@@ -0,0 +1,139 @@
+package phonelist_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/otp"
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+	memoryphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/memory"
+	redisphonelist "github.com/TheAmirMohammad/otp-service/internal/phonelist/redis"
+)
+
+func stores(t *testing.T) map[string]func() phonelist.Store {
+	return map[string]func() phonelist.Store{
+		"memory": memoryphonelist.NewStore,
+		"redis": func() phonelist.Store {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { _ = rdb.Close() })
+			return redisphonelist.NewStore(rdb)
+		},
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			l, err := phonelist.New(newStore(), []phonelist.Entry{
+				{Pattern: "+98999*", Kind: phonelist.KindBlock},
+				{Pattern: "+989990000001", Kind: phonelist.KindTest, Code: "424242"},
+			}, otp.Format{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			check := func(phone string, wantKind string, wantErr error) {
+				t.Helper()
+				e, err := l.Check(ctx, phone)
+				if !errors.Is(err, wantErr) {
+					t.Fatalf("Check(%s) err = %v, want %v", phone, err, wantErr)
+				}
+				got := ""
+				if e != nil {
+					got = e.Kind
+				}
+				if got != wantKind {
+					t.Fatalf("Check(%s) = %q, want %q", phone, got, wantKind)
+				}
+			}
+
+			check("+989121234567", "", nil)
+			check("+989990000002", phonelist.KindBlock, phonelist.ErrBlocked)
+			check("+989990000001", phonelist.KindTest, nil) // exact beats prefix
+
+			if _, err := l.Put(ctx, phonelist.Entry{Pattern: "+98912*", Kind: phonelist.KindBlock, Note: "abuse"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := l.Put(ctx, phonelist.Entry{Pattern: "+989121*", Kind: phonelist.KindAllow}); err != nil {
+				t.Fatal(err)
+			}
+			check("+989125550000", phonelist.KindBlock, phonelist.ErrBlocked)
+			check("+989121234567", phonelist.KindAllow, nil) // longer prefix wins
+
+			if code, ok, err := l.TestCode(ctx, "+989990000001"); err != nil || !ok || code != "424242" {
+				t.Fatalf("TestCode = %q, %v, %v", code, ok, err)
+			}
+			if _, ok, _ := l.TestCode(ctx, "+989121234567"); ok {
+				t.Fatal("TestCode of a regular phone: ok")
+			}
+
+			entries, err := l.Entries(ctx)
+			if err != nil || len(entries) != 4 {
+				t.Fatalf("Entries = %v, %v", entries, err)
+			}
+			for _, e := range entries {
+				want := phonelist.SourceAdmin
+				if e.Pattern == "+98999*" || e.Pattern == "+989990000001" {
+					want = phonelist.SourceConfig
+				}
+				if e.Source != want {
+					t.Errorf("%s: source %s, want %s", e.Pattern, e.Source, want)
+				}
+			}
+
+			if _, err := l.Put(ctx, phonelist.Entry{Pattern: "+98999*", Kind: phonelist.KindAllow}); !errors.Is(err, phonelist.ErrReadOnly) {
+				t.Fatalf("Put over a config entry: %v", err)
+			}
+			if _, err := l.Delete(ctx, "+98999*"); !errors.Is(err, phonelist.ErrReadOnly) {
+				t.Fatalf("Delete of a config entry: %v", err)
+			}
+			if ok, err := l.Delete(ctx, "+98912*"); err != nil || !ok {
+				t.Fatalf("Delete = %v, %v", ok, err)
+			}
+			if ok, _ := l.Delete(ctx, "+98912*"); ok {
+				t.Fatal("second Delete reported an entry")
+			}
+			check("+989125550000", "", nil)
+		})
+	}
+}
+
+func TestEntryValidate(t *testing.T) {
+	for _, e := range []phonelist.Entry{
+		{Pattern: "989121234567", Kind: phonelist.KindBlock},
+		{Pattern: "+98 912", Kind: phonelist.KindBlock},
+		{Pattern: "+98912*", Kind: "mute"},
+		{Pattern: "+98912*", Kind: phonelist.KindTest, Code: "1234"},
+		{Pattern: "+989121234567", Kind: phonelist.KindTest, Code: "12ab"},
+		{Pattern: "+989121234567", Kind: phonelist.KindBlock, Code: "1234"},
+	} {
+		if err := e.Validate(otp.Format{}); !errors.Is(err, phonelist.ErrInvalid) {
+			t.Errorf("Validate(%+v) = %v, want ErrInvalid", e, err)
+		}
+	}
+	if _, err := phonelist.New(memoryphonelist.NewStore(), []phonelist.Entry{
+		{Pattern: "+98912*", Kind: phonelist.KindBlock},
+		{Pattern: "+98912*", Kind: phonelist.KindAllow},
+	}, otp.Format{}); err == nil {
+		t.Error("New with a duplicate pattern: want an error")
+	}
+
+	f, _ := otp.NewFormat(4, "alphanumeric")
+	l, err := phonelist.New(memoryphonelist.NewStore(), []phonelist.Entry{
+		{Pattern: "+989990000001", Kind: phonelist.KindTest, Code: "ab23"},
+	}, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _, _ := l.TestCode(context.Background(), "+989990000001"); code != "AB23" {
+		t.Errorf("test code %q, want it upper-cased", code)
+	}
+	if _, err := l.Put(context.Background(), phonelist.Entry{Pattern: "+989990000002", Kind: phonelist.KindTest, Code: "424242"}); !errors.Is(err, phonelist.ErrInvalid) {
+		t.Errorf("Put of a 6-digit code with a 4-character format: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package redisphonelist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/phonelist"
+)
+
+// key is a hash: pattern -> JSON entry. Lookups fetch every candidate pattern with one HMGET.
+const key = "phonelist"
+
+type store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) phonelist.Store {
+	return &store{rdb: rdb}
+}
+
+func (s *store) Get(ctx context.Context, patterns []string) ([]*phonelist.Entry, error) {
+	vals, err := s.rdb.HMGet(ctx, key, patterns...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*phonelist.Entry, len(patterns))
+	for i, v := range vals {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var e phonelist.Entry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			return nil, fmt.Errorf("phone list %s: %w", patterns[i], err)
+		}
+		out[i] = &e
+	}
+	return out, nil
+}
+
+func (s *store) List(ctx context.Context) ([]phonelist.Entry, error) {
+	all, err := s.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]phonelist.Entry, 0, len(all))
+	for p, raw := range all {
+		var e phonelist.Entry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			return nil, fmt.Errorf("phone list %s: %w", p, err)
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *store) Put(ctx context.Context, e phonelist.Entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, key, e.Pattern, raw).Err()
+}
+
+func (s *store) Delete(ctx context.Context, pattern string) (bool, error) {
+	n, err := s.rdb.HDel(ctx, key, pattern).Result()
+	return n > 0, err
+}
@@ -0,0 +1,70 @@
+package memoryrevocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+)
+
+type store struct {
+	mu      sync.RWMutex
+	userTTL time.Duration
+	tokens  map[string]time.Time // jti -> token expiry
+	users   map[string]cutoff    // user id -> tokens issued before are revoked
+}
+
+type cutoff struct {
+	Before    time.Time // truncated to the second, like iat
+	ExpiresAt time.Time
+}
+
+// NewStore keeps per-user cutoffs for userTTL (the longest token lifetime plus leeway).
+func NewStore(userTTL time.Duration) revocation.Store {
+	return &store{userTTL: userTTL, tokens: make(map[string]time.Time), users: make(map[string]cutoff)}
+}
+
+func (s *store) RevokeToken(_ context.Context, jti string, exp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	s.tokens[jti] = exp
+	return nil
+}
+
+func (s *store) RevokeUser(_ context.Context, userID string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	before = before.Truncate(time.Second)
+	s.users[userID] = cutoff{Before: before, ExpiresAt: before.Add(s.userTTL)}
+	return nil
+}
+
+func (s *store) IsRevoked(_ context.Context, jti, userID string, iat time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	if exp, ok := s.tokens[jti]; ok && now.Before(exp) {
+		return true, nil
+	}
+	if c, ok := s.users[userID]; ok && now.Before(c.ExpiresAt) && !iat.After(c.Before) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// prune drops entries whose tokens have expired anyway (caller holds the lock).
+func (s *store) prune(now time.Time) {
+	for jti, exp := range s.tokens {
+		if !now.Before(exp) {
+			delete(s.tokens, jti)
+		}
+	}
+	for id, c := range s.users {
+		if !now.Before(c.ExpiresAt) {
+			delete(s.users, id)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package memoryrevocation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRevokeUserCutoff(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(time.Minute)
+	now := time.Now()
+	if err := s.RevokeUser(ctx, "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	// iat has second precision: the whole second of the revocation is caught
+	iat := func(d time.Duration) time.Time { return now.Add(d).Truncate(time.Second) }
+	for _, tc := range []struct {
+		name string
+		user string
+		iat  time.Time
+		want bool
+	}{
+		{"previous second", "alice", iat(-time.Second), true},
+		{"same second", "alice", iat(0), true},
+		{"next second", "alice", iat(time.Second), false},
+		{"other user", "bob", iat(-time.Second), false},
+	} {
+		if got, err := s.IsRevoked(ctx, "jti", tc.user, tc.iat); err != nil || got != tc.want {
+			t.Errorf("%s: IsRevoked = %v, %v, want %v", tc.name, got, err, tc.want)
+		}
+	}
+
+	st := s.(*store)
+	st.prune(now.Add(time.Minute))
+	if len(st.users) != 0 {
+		t.Fatal("cutoff kept past its ttl")
+	}
+}
@@ -0,0 +1,61 @@
+package redisrevocation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/TheAmirMohammad/otp-service/internal/revocation"
+)
+
+type store struct {
+	rdb     *redis.Client
+	userTTL time.Duration
+}
+
+// NewStore keeps per-user cutoffs for userTTL (the longest token lifetime plus leeway).
+func NewStore(rdb *redis.Client, userTTL time.Duration) revocation.Store {
+	return &store{rdb: rdb, userTTL: userTTL}
+}
+
+// revoked:jti:<jti> -> 1, expiring with the token
+func (s *store) RevokeToken(ctx context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.rdb.Set(ctx, fmt.Sprintf("revoked:jti:%s", jti), 1, ttl).Err()
+}
+
+// revoked:user:<id> -> unix seconds; tokens issued in or before that second are revoked
+func (s *store) RevokeUser(ctx context.Context, userID string, before time.Time) error {
+	return s.rdb.Set(ctx, fmt.Sprintf("revoked:user:%s", userID), before.Unix(), s.userTTL).Err()
+}
+
+func (s *store) IsRevoked(ctx context.Context, jti, userID string, iat time.Time) (bool, error) {
+	var (
+		jtiCmd  *redis.IntCmd
+		userCmd *redis.StringCmd
+	)
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		jtiCmd = p.Exists(ctx, fmt.Sprintf("revoked:jti:%s", jti))
+		userCmd = p.Get(ctx, fmt.Sprintf("revoked:user:%s", userID))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if jtiCmd.Val() > 0 {
+		return true, nil
+	}
+	before, err := userCmd.Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return iat.Unix() <= before, nil
+}
@@ -0,0 +1,50 @@
+package redisrevocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRevokeUserCutoff(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	s := NewStore(rdb, time.Minute)
+
+	now := time.Now()
+	if err := s.RevokeUser(ctx, "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	iat := func(d time.Duration) time.Time { return now.Add(d).Truncate(time.Second) }
+	for _, tc := range []struct {
+		name string
+		user string
+		iat  time.Time
+		want bool
+	}{
+		{"previous second", "alice", iat(-time.Second), true},
+		{"same second", "alice", iat(0), true},
+		{"next second", "alice", iat(time.Second), false},
+		{"other user", "bob", iat(-time.Second), false},
+	} {
+		if got, err := s.IsRevoked(ctx, "jti", tc.user, tc.iat); err != nil || got != tc.want {
+			t.Errorf("%s: IsRevoked = %v, %v, want %v", tc.name, got, err, tc.want)
+		}
+	}
+
+	if err := s.RevokeToken(ctx, "jti-1", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.IsRevoked(ctx, "jti-1", "bob", iat(0)); !got {
+		t.Error("revoked jti accepted")
+	}
+	mr.FastForward(time.Minute + time.Second)
+	if got, _ := s.IsRevoked(ctx, "jti", "alice", iat(-time.Second)); got {
+		t.Error("cutoff kept past its ttl")
+	}
+}
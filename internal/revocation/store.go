@@ -0,0 +1,20 @@
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the access-token denylist consulted by the auth middleware
+// (both memory & redis implement). Entries only live as long as the
+// tokens they revoke could still be valid.
+type Store interface {
+	// RevokeToken denylists one token (its jti) until it expires.
+	RevokeToken(ctx context.Context, jti string, exp time.Time) error
+	// RevokeUser invalidates every token of userID issued at or before `before`. Token iat
+	// only has second precision, so the whole second of the revocation is covered: a
+	// token minted in that second, even just after it, is revoked too.
+	RevokeUser(ctx context.Context, userID string, before time.Time) error
+	// IsRevoked reports whether a token (jti, subject, issued-at) has been revoked.
+	IsRevoked(ctx context.Context, jti, userID string, iat time.Time) (bool, error)
+}
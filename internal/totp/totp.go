@@ -0,0 +1,97 @@
+// Package totp implements RFC 6238 time-based one-time passwords (authenticator apps):
+// HMAC-SHA1, 30 second steps, 6 digits – the defaults every app understands.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	period     = 30 // seconds per step
+	digits     = 6
+	modulo     = 1_000_000 // 10^digits
+	skew       = 1         // accepted steps before/after now (clock drift)
+	secretSize = 20        // bytes, as recommended by RFC 4226
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// URI builds the otpauth:// provisioning URI (usually rendered as a QR code).
+func URI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(digits))
+	q.Set("period", fmt.Sprint(period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/period)), nil
+}
+
+// Verify checks code for secret at time t, allowing ±skew steps, and returns the
+// step it matched. Steps at or below last (the step of the previous accepted code,
+// 0 for none) are refused, so a code cannot be replayed (RFC 6238 §5.2); the caller
+// stores the returned step as the new last.
+func Verify(secret, code string, t time.Time, last int64) (int64, bool) {
+	key, err := decode(secret)
+	if err != nil || len(code) != digits {
+		return 0, false
+	}
+	now := t.Unix() / period
+	var matched int64
+	for i := -skew; i <= skew; i++ {
+		// no early exit: all steps are always compared
+		step := now + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(step))), []byte(code)) == 1 && step > last {
+			matched = step
+		}
+	}
+	return matched, matched > 0
+}
+
+func decode(secret string) ([]byte, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, fmt.Errorf("totp: invalid secret: %w", err)
+	}
+	return key, nil
+}
+
+// hotp is RFC 4226 with dynamic truncation.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, n%modulo)
+}
@@ -0,0 +1,122 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B, SHA1 column: the 8-digit values truncated to our 6 digits.
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	} {
+		got, err := Code(secret, time.Unix(tc.unix, 0))
+		if err != nil || got != tc.want {
+			t.Errorf("Code(T=%d) = %q, %v; want %q", tc.unix, got, err, tc.want)
+		}
+	}
+}
+
+func TestVerifyWindow(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_010, 0) // 10s into a step
+	step := now.Unix() / period
+	codeAt := func(d time.Duration) string {
+		c, err := Code(secret, now.Add(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	for _, tc := range []struct {
+		name string
+		code string
+		want int64 // matched step, 0 when rejected
+	}{
+		{"current", codeAt(0), step},
+		{"previous step (skew)", codeAt(-period * time.Second), step - 1},
+		{"next step (skew)", codeAt(period * time.Second), step + 1},
+		{"two steps old", codeAt(-2 * period * time.Second), 0},
+		{"two steps ahead", codeAt(2 * period * time.Second), 0},
+		{"wrong length", codeAt(0)[:5], 0},
+		{"garbage", "abcdef", 0},
+	} {
+		got, ok := Verify(secret, tc.code, now, 0)
+		if got != tc.want || ok != (tc.want != 0) {
+			t.Errorf("%s: Verify = %d, %v; want %d", tc.name, got, ok, tc.want)
+		}
+	}
+	if _, ok := Verify("not base32!", codeAt(0), now, 0); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Unix(1_700_000_010, 0)
+	code, _ := Code(secret, now)
+
+	last, ok := Verify(secret, code, now, 0)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	if _, ok := Verify(secret, code, now, last); ok {
+		t.Fatal("code replayed within its step")
+	}
+	if _, ok := Verify(secret, code, now.Add(period*time.Second), last); ok {
+		t.Fatal("code replayed in the next step (skew)")
+	}
+	// an older code still inside the window is refused once a newer one was used
+	prev, _ := Code(secret, now.Add(-period*time.Second))
+	if _, ok := Verify(secret, prev, now, last); ok {
+		t.Fatal("older code accepted after a newer one")
+	}
+	next, _ := Code(secret, now.Add(period*time.Second))
+	if got, ok := Verify(secret, next, now.Add(period*time.Second), last); !ok || got != last+1 {
+		t.Fatalf("next step = %d, %v", got, ok)
+	}
+}
+
+func TestCipher(t *testing.T) {
+	old, _ := NewCipher("old-key", nil)
+	c, err := NewCipher("new-key", []string{"old-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secret = "JBSWY3DPEHPK3PXP"
+
+	sealed, err := c.Seal("alice", secret)
+	if err != nil || sealed == secret {
+		t.Fatalf("Seal = %q, %v", sealed, err)
+	}
+	if got, current, err := c.Open("alice", sealed); err != nil || got != secret || !current {
+		t.Fatalf("Open = %q, %v, %v", got, current, err)
+	}
+	if _, _, err := c.Open("bob", sealed); err != ErrUnsealable {
+		t.Fatalf("Open with another user = %v, want ErrUnsealable", err)
+	}
+
+	byOld, _ := old.Seal("alice", secret)
+	if got, current, err := c.Open("alice", byOld); err != nil || got != secret || current {
+		t.Fatalf("Open(previous key) = %q, %v, %v; want the secret, not current", got, current, err)
+	}
+	if _, _, err := old.Open("alice", sealed); err != ErrUnsealable {
+		t.Fatalf("Open without the key = %v, want ErrUnsealable", err)
+	}
+	if got, current, err := c.Open("alice", secret); err != nil || got != secret || current {
+		t.Fatalf("Open(plaintext) = %q, %v, %v; want it as is, not current", got, current, err)
+	}
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS refresh_tokens_revoked_idx;
+DROP INDEX IF EXISTS refresh_tokens_expires_idx;
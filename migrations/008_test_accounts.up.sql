@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS test_account BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+// Package migrations embeds the numbered SQL files applied by postgres.Migrator.
+//
+// Files are named NNN_name.up.sql / NNN_name.down.sql. Up files stay idempotent
+// (IF NOT EXISTS) so databases created before versioning adopt them cleanly.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS